@@ -1,10 +1,7 @@
 package health
 
 import (
-	"fmt"
 	"net/http"
-	"os"
-	"strings"
 	"time"
 
 	"github.com/byuoitav/common/log"
@@ -53,22 +50,11 @@ func publishEvent(publish func(events.Event), k string, v string, name string) {
 	publish(BuildEvent(k, v, name))
 }
 
+// BuildEvent builds an event from this system about Device, which is usually the name of the microservice.
 func BuildEvent(Key string, Value string, Device string) events.Event {
-	hostname := os.Getenv("SYSTEM_ID")
-	split := strings.Split(hostname, "-")
-	room := fmt.Sprintf("%s-%s", split[0], split[1])
-
-	roomInfo := events.GenerateBasicRoomInfo(room)
-
-	deviceInfo := events.GenerateBasicDeviceInfo(Device)
-
-	e := events.Event{
-		GeneratingSystem: hostname,
-		Timestamp:        time.Now(),
-		AffectedRoom:     roomInfo,
-		TargetDevice:     deviceInfo,
-		Key:              Key,
-		Value:            Value,
+	e, err := events.NewBuilder().Target(Device).KeyValue(Key, Value).Build()
+	if err != nil {
+		log.L.Warnf("[HealthCheck] Built an invalid event: %s", err)
 	}
 
 	return e
@@ -0,0 +1,169 @@
+package inputgraph
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/byuoitav/common/log"
+	"github.com/byuoitav/common/state/statedefinition"
+	"github.com/byuoitav/common/structs"
+)
+
+// ActivePath is the path a signal is currently taking to a display, based on the input each device has selected.
+type ActivePath struct {
+	Display string `json:"display"`
+
+	// Path is ordered from the farthest device traced back to the display
+	Path []Node `json:"path"`
+
+	// Source is the device the signal starts at, if the trace made it all the way back to one
+	Source string `json:"source,omitempty"`
+
+	// Breaks are the problems found along the path
+	Breaks []Break `json:"breaks,omitempty"`
+}
+
+// Break is a problem along an active path that keeps the signal from getting to the display.
+type Break struct {
+	DeviceID string `json:"device-id"`
+	Reason   string `json:"reason"`
+}
+
+// Complete returns true if the path made it back to a source without any breaks.
+func (a ActivePath) Complete() bool {
+	return len(a.Source) > 0 && len(a.Breaks) == 0
+}
+
+// GetActivePaths traces the active path for each display. See GetActivePath.
+// A display that can't be traced (e.g. a mirror that isn't in the graph) gets a path with a break explaining why, instead of failing every other display.
+func GetActivePaths(displays []string, states []statedefinition.StaticDevice, ig InputGraph) (map[string]ActivePath, error) {
+	paths := make(map[string]ActivePath)
+
+	for _, display := range displays {
+		path, err := GetActivePath(display, states, ig)
+		if err != nil {
+			log.L.Warnf("[inputgraph] Unable to trace the active path to %v: %s", display, err)
+			path = ActivePath{
+				Display: display,
+				Breaks:  []Break{Break{DeviceID: display, Reason: err.Error()}},
+			}
+		}
+
+		paths[display] = path
+	}
+
+	return paths, nil
+}
+
+// GetActivePath traces back from display through the input each device currently has selected, until it reaches a source.
+// Devices with only one input (like an HDBaseT receiver) don't need to report an input, and mirrors are traced through their master. A device that is powered off, has no active signal, or has no input selected is flagged as a break.
+func GetActivePath(display string, states []statedefinition.StaticDevice, ig InputGraph) (ActivePath, error) {
+	if err := ig.checkDevices(display); err != nil {
+		return ActivePath{}, err
+	}
+
+	stateMap := make(map[string]statedefinition.StaticDevice)
+	for _, state := range states {
+		stateMap[strings.ToUpper(state.DeviceID)] = state
+	}
+
+	active := ActivePath{Display: display}
+	visited := map[string]bool{}
+	ids := []string{}
+
+	for cur := display; len(cur) > 0; {
+		if visited[cur] {
+			active.Breaks = append(active.Breaks, Break{DeviceID: cur, Reason: "input selection loops back on itself"})
+			break
+		}
+
+		visited[cur] = true
+		ids = append(ids, cur)
+
+		state, hasState := stateMap[strings.ToUpper(cur)]
+		if hasState {
+			if len(state.Power) > 0 && !strings.EqualFold(state.Power, "on") {
+				active.Breaks = append(active.Breaks, Break{DeviceID: cur, Reason: fmt.Sprintf("power is %v", state.Power)})
+			}
+
+			if state.ActiveSignal != nil && !*state.ActiveSignal {
+				active.Breaks = append(active.Breaks, Break{DeviceID: cur, Reason: "no active signal"})
+			}
+		}
+
+		inputs := ig.AdjacencyMap[cur]
+		master := ig.mirrorMaster(cur)
+
+		switch {
+		case len(master) > 0:
+			// a mirror shows whatever its master is showing, no matter what input it reports
+			cur = master
+		case len(inputs) == 0:
+			// nothing feeds into this device, so it's the source
+			active.Source = cur
+			cur = ""
+		case len(state.Input) > 0:
+			next := ig.selectedInput(cur, state.Input)
+			if len(next) == 0 {
+				active.Breaks = append(active.Breaks, Break{DeviceID: cur, Reason: fmt.Sprintf("selected input %v isn't connected", state.Input)})
+			}
+
+			cur = next
+		case len(inputs) == 1:
+			cur = inputs[0]
+		case !hasState:
+			active.Breaks = append(active.Breaks, Break{DeviceID: cur, Reason: "no state reported"})
+			cur = ""
+		default:
+			active.Breaks = append(active.Breaks, Break{DeviceID: cur, Reason: "no input selected"})
+			cur = ""
+		}
+	}
+
+	active.Path = ig.nodes(reverse(ids))
+
+	log.L.Debugf("[inputgraph] Active path to %v: %v (%v breaks)", display, ids, len(active.Breaks))
+	return active, nil
+}
+
+// GetVideoDisplays returns the ID of every device in the graph with the VideoOut role, including devices they mirror to.
+func (ig InputGraph) GetVideoDisplays() []string {
+	displays := []string{}
+
+	for _, node := range ig.Nodes {
+		if structs.HasRole(node.Device, "VideoOut") {
+			displays = append(displays, node.ID)
+			displays = append(displays, GetMirrors(node.Device)...)
+		}
+	}
+
+	return displays
+}
+
+// selectedInput finds which device feeding into dest matches input, which may be a device ID, a device name, or the ID of the port it's connected to
+func (ig InputGraph) selectedInput(dest, input string) string {
+	for _, src := range ig.AdjacencyMap[dest] {
+		if strings.EqualFold(src, input) || strings.EqualFold(ig.shortName(structs.GetRoomIDFromDevice(src), src), input) {
+			return src
+		}
+	}
+
+	for _, edge := range ig.Edges[dest] {
+		if strings.EqualFold(edge.Port, input) {
+			return edge.Source
+		}
+	}
+
+	return ""
+}
+
+// mirrorMaster returns the device that id mirrors, if it's a mirror
+func (ig InputGraph) mirrorMaster(id string) string {
+	for _, edge := range ig.Edges[id] {
+		if edge.mirror() {
+			return edge.Source
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,60 @@
+package inputgraph
+
+import (
+	"testing"
+
+	"github.com/byuoitav/common/state/statedefinition"
+)
+
+func TestActivePath(t *testing.T) {
+	graph, err := BuildGraph(room1.Devices, "video")
+	if err != nil {
+		t.Fatalf("failed to build graph: %s", err)
+	}
+
+	signal := true
+	states := []statedefinition.StaticDevice{
+		statedefinition.StaticDevice{DeviceID: "ITB-1101-D1", Power: "on", Input: "HDMI1", ActiveSignal: &signal},
+		statedefinition.StaticDevice{DeviceID: "ITB-1101-SW1", Input: "IN1"},
+
+		// the mirror reports its own input, which isn't connected to anything
+		statedefinition.StaticDevice{DeviceID: "ITB-1101-D2", Power: "on", Input: "HDMI3"},
+	}
+
+	// a display that isn't in the graph doesn't keep the others from being traced
+	displays := append(graph.GetVideoDisplays(), "ITB-1101-D9")
+
+	paths, err := GetActivePaths(displays, states, graph)
+	if err != nil {
+		t.Fatalf("failed to get active paths: %s", err)
+	}
+
+	if missing := paths["ITB-1101-D9"]; missing.Complete() || len(missing.Breaks) != 1 {
+		t.Fatalf("expected ITB-1101-D9 to have a break, got %+v", missing)
+	}
+
+	for _, display := range []string{"ITB-1101-D1", "ITB-1101-D2"} {
+		path := paths[display]
+		if !path.Complete() || path.Source != "ITB-1101-HDMI1" {
+			t.Fatalf("expected %v to show ITB-1101-HDMI1, got %+v", display, path)
+		}
+	}
+
+	// the switcher is off and doesn't know what it's showing
+	states[1] = statedefinition.StaticDevice{DeviceID: "ITB-1101-SW1", Power: "standby"}
+
+	path, err := GetActivePath("ITB-1101-D1", states, graph)
+	if err != nil {
+		t.Fatalf("failed to get active path: %s", err)
+	}
+
+	if path.Complete() || len(path.Breaks) != 2 || len(path.Path) != 2 {
+		t.Fatalf("expected the path to break at ITB-1101-SW1, got %+v", path)
+	}
+
+	for _, b := range path.Breaks {
+		if b.DeviceID != "ITB-1101-SW1" {
+			t.Fatalf("expected the path to break at ITB-1101-SW1, got %+v", b)
+		}
+	}
+}
@@ -0,0 +1,339 @@
+package inputgraph
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/byuoitav/common/log"
+	"github.com/byuoitav/common/structs"
+)
+
+// Port tags that describe the capabilities of a connection
+const (
+	// HDCPTag marks a port as supporting HDCP
+	HDCPTag = "hdcp"
+
+	// MaxResolutionTag is the prefix for a port's max resolution, e.g. max-resolution=3840x2160
+	MaxResolutionTag = "max-resolution="
+
+	// LatencyTag is the prefix for the latency a port adds, e.g. latency=20ms
+	LatencyTag = "latency="
+)
+
+// Edge is a single connection from one device into another.
+type Edge struct {
+	Port        string `json:"port"`
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+
+	Capabilities
+}
+
+// Capabilities describes what a connection is able to carry.
+type Capabilities struct {
+	// PortType is the type of the port, e.g. hdmi or hdbaset
+	PortType string `json:"port-type,omitempty"`
+
+	// MaxResolution is the largest resolution the connection can carry. A zero value means it's unknown
+	MaxResolution Resolution `json:"max-resolution"`
+
+	// HDCP is true if the connection supports HDCP
+	HDCP bool `json:"hdcp"`
+
+	// Latency is how much delay the connection adds
+	Latency time.Duration `json:"latency,omitempty"`
+}
+
+// Requirements are what a path has to support to be usable.
+// A connection whose max resolution or latency is unknown isn't rejected for it, but a connection has to be tagged with hdcp to satisfy HDCP.
+type Requirements struct {
+	// PortTypes are the port types that are allowed. If empty, any port type is allowed
+	PortTypes []string `json:"port-types,omitempty"`
+
+	// MinResolution is the resolution each connection has to be able to carry
+	MinResolution Resolution `json:"min-resolution"`
+
+	// HDCP requires each connection to support HDCP
+	HDCP bool `json:"hdcp,omitempty"`
+
+	// MaxLatency is the most latency the whole path can add. If zero, latency isn't checked
+	MaxLatency time.Duration `json:"max-latency,omitempty"`
+}
+
+// Resolution is a video resolution.
+type Resolution struct {
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+var resolutionAliases = map[string]Resolution{
+	"720p":  Resolution{Width: 1280, Height: 720},
+	"1080p": Resolution{Width: 1920, Height: 1080},
+	"4k":    Resolution{Width: 3840, Height: 2160},
+}
+
+// ParseResolution parses a resolution like 1920x1080, or one of 720p, 1080p, or 4k.
+func ParseResolution(s string) (Resolution, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+
+	if res, ok := resolutionAliases[s]; ok {
+		return res, nil
+	}
+
+	split := strings.Split(s, "x")
+	if len(split) != 2 {
+		return Resolution{}, fmt.Errorf("invalid resolution %q: must be in the form WIDTHxHEIGHT", s)
+	}
+
+	width, err := strconv.Atoi(split[0])
+	if err != nil {
+		return Resolution{}, fmt.Errorf("invalid resolution %q: %s", s, err)
+	}
+
+	height, err := strconv.Atoi(split[1])
+	if err != nil {
+		return Resolution{}, fmt.Errorf("invalid resolution %q: %s", s, err)
+	}
+
+	return Resolution{Width: width, Height: height}, nil
+}
+
+// IsZero returns true if the resolution is unknown.
+func (r Resolution) IsZero() bool {
+	return r.Width == 0 && r.Height == 0
+}
+
+// Supports returns true if r is at least as large as other.
+func (r Resolution) Supports(other Resolution) bool {
+	return r.Width >= other.Width && r.Height >= other.Height
+}
+
+func (r Resolution) String() string {
+	return fmt.Sprintf("%dx%d", r.Width, r.Height)
+}
+
+// GetCapabilities reads the capabilities of a connection from a port's type and tags.
+func GetCapabilities(port structs.Port) Capabilities {
+	c := Capabilities{
+		PortType: strings.ToLower(port.PortType),
+	}
+
+	for _, tag := range port.Tags {
+		switch {
+		case strings.EqualFold(tag, HDCPTag):
+			c.HDCP = true
+		case strings.HasPrefix(tag, MaxResolutionTag):
+			res, err := ParseResolution(strings.TrimPrefix(tag, MaxResolutionTag))
+			if err != nil {
+				log.L.Warnf("[inputgraph] Ignoring max resolution on port %v: %s", port.ID, err)
+				continue
+			}
+
+			c.MaxResolution = res
+		case strings.HasPrefix(tag, LatencyTag):
+			latency, err := time.ParseDuration(strings.TrimPrefix(tag, LatencyTag))
+			if err != nil {
+				log.L.Warnf("[inputgraph] Ignoring latency on port %v: %s", port.ID, err)
+				continue
+			}
+
+			c.Latency = latency
+		}
+	}
+
+	return c
+}
+
+// Check returns an empty string if the connection meets the requirements, or the reason it doesn't. Latency is checked against the whole path, not here.
+func (c Capabilities) Check(req Requirements) string {
+	if len(req.PortTypes) > 0 {
+		allowed := false
+		for _, t := range req.PortTypes {
+			if strings.EqualFold(t, c.PortType) {
+				allowed = true
+				break
+			}
+		}
+
+		if !allowed {
+			return fmt.Sprintf("port type %q is not one of %v", c.PortType, req.PortTypes)
+		}
+	}
+
+	if req.HDCP && !c.HDCP {
+		return "does not support HDCP"
+	}
+
+	if !req.MinResolution.IsZero() && !c.MaxResolution.IsZero() && !c.MaxResolution.Supports(req.MinResolution) {
+		return fmt.Sprintf("max resolution %v is less than %v", c.MaxResolution, req.MinResolution)
+	}
+
+	return ""
+}
+
+// CapablePath is a path along with the connections chosen between each device on it.
+type CapablePath struct {
+	Path    []Node        `json:"path"`
+	Edges   []Edge        `json:"edges"`
+	Latency time.Duration `json:"latency"`
+}
+
+// Rejection explains why a connection, or the best path that was left, can't be used. A rejected connection's Path is just the two devices it connects.
+type Rejection struct {
+	Path   []Node `json:"path"`
+	Reason string `json:"reason"`
+}
+
+// CheckCapableReachability looks for the path from deviceB (the source) to deviceA (the sink) with the lowest latency where every connection meets req.
+// Each connection that was rejected is returned along with the reason why, and so is the best path if it adds more latency than req allows.
+// A mirror connection carries whatever its master is showing, so it's never rejected itself; it inherits the capabilities of the connection into the master.
+func CheckCapableReachability(deviceA, deviceB string, req Requirements, ig InputGraph) (bool, CapablePath, []Rejection, error) {
+	if err := ig.checkDevices(deviceA, deviceB); err != nil {
+		return false, CapablePath{}, []Rejection{}, err
+	}
+
+	fromSource := ig.downstream(deviceB)
+	rejections := []Rejection{}
+
+	// dist is the lowest latency from each device to the sink over connections that meet req, and next is the connection to take to get there
+	dist := map[string]time.Duration{deviceA: 0}
+	next := map[string]Edge{}
+	done := map[string]bool{}
+	reached := false
+
+	for {
+		cur, found := "", false
+		for id, d := range dist {
+			if !done[id] && (!found || d < dist[cur] || (d == dist[cur] && id < cur)) {
+				cur, found = id, true
+			}
+		}
+
+		if !found {
+			break
+		}
+
+		if cur == deviceB {
+			reached = true
+			break
+		}
+
+		done[cur] = true
+
+		// find the lowest latency connection that meets req from each device feeding into cur
+		sources := []string{}
+		best := map[string]Edge{}
+		reasons := map[string]string{}
+
+		for _, edge := range ig.Edges[cur] {
+			if done[edge.Source] {
+				continue
+			}
+
+			if _, ok := best[edge.Source]; !ok && len(reasons[edge.Source]) == 0 {
+				sources = append(sources, edge.Source)
+			}
+
+			if !edge.mirror() {
+				if r := edge.Check(req); len(r) > 0 {
+					if len(reasons[edge.Source]) == 0 {
+						reasons[edge.Source] = fmt.Sprintf("port %v from %v to %v %s", edge.Port, edge.Source, cur, r)
+					}
+					continue
+				}
+			}
+
+			if b, ok := best[edge.Source]; !ok || edge.Latency < b.Latency {
+				best[edge.Source] = edge
+			}
+		}
+
+		for _, src := range sources {
+			edge, ok := best[src]
+			if !ok {
+				if fromSource[src] {
+					log.L.Debugf("[inputgraph] Rejecting connection from %v to %v: %s", src, cur, reasons[src])
+					rejections = append(rejections, Rejection{Path: ig.nodes([]string{src, cur}), Reason: reasons[src]})
+				}
+				continue
+			}
+
+			latency := dist[cur]
+			if !edge.mirror() {
+				latency += edge.Latency
+			}
+
+			if d, ok := dist[src]; !ok || latency < d {
+				dist[src] = latency
+				next[src] = edge
+			}
+		}
+	}
+
+	if !reached {
+		return false, CapablePath{}, rejections, nil
+	}
+
+	capable := CapablePath{Latency: dist[deviceB]}
+
+	ids := []string{deviceB}
+	for cur := deviceB; cur != deviceA; {
+		edge := next[cur]
+
+		// a mirror shows whatever its master gets
+		if edge.mirror() && len(capable.Edges) > 0 {
+			edge.Capabilities = capable.Edges[len(capable.Edges)-1].Capabilities
+			edge.Latency = 0
+		}
+
+		capable.Edges = append(capable.Edges, edge)
+		cur = edge.Destination
+		ids = append(ids, cur)
+	}
+
+	capable.Path = ig.nodes(ids)
+
+	if req.MaxLatency > 0 && capable.Latency > req.MaxLatency {
+		reason := fmt.Sprintf("latency %v is more than %v", capable.Latency, req.MaxLatency)
+		log.L.Debugf("[inputgraph] Rejecting path from %v to %v: %s", deviceB, deviceA, reason)
+
+		rejections = append(rejections, Rejection{Path: capable.Path, Reason: reason})
+		return false, CapablePath{}, rejections, nil
+	}
+
+	return true, capable, rejections, nil
+}
+
+// mirror returns true if the edge is from a MirrorMaster to a device it mirrors
+func (e Edge) mirror() bool {
+	return e.Port == MirrorPortID
+}
+
+// downstream returns every device that id feeds into, including itself
+func (ig InputGraph) downstream(id string) map[string]bool {
+	feeds := map[string][]string{}
+	for dest, sources := range ig.AdjacencyMap {
+		for _, src := range sources {
+			feeds[src] = append(feeds[src], dest)
+		}
+	}
+
+	visited := map[string]bool{id: true}
+	queue := []string{id}
+
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+
+		for _, next := range feeds[cur] {
+			if !visited[next] {
+				visited[next] = true
+				queue = append(queue, next)
+			}
+		}
+	}
+
+	return visited
+}
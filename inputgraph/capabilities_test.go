@@ -0,0 +1,128 @@
+package inputgraph
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/byuoitav/common/structs"
+)
+
+// pc -> sw -> proj over hdbaset, limited to 1080p
+// pc -> sw -> scaler -> proj over hdmi, 4k with some latency
+var capabilityDevices = []structs.Device{
+	structs.Device{ID: "pc"},
+	structs.Device{
+		ID: "sw",
+		Ports: []structs.Port{
+			structs.Port{SourceDevice: "pc", ID: "in1", DestinationDevice: "sw", PortType: "HDMI", Tags: []string{"video", HDCPTag, MaxResolutionTag + "4k"}},
+		},
+	},
+	structs.Device{
+		ID: "scaler",
+		Ports: []structs.Port{
+			structs.Port{SourceDevice: "sw", ID: "in1", DestinationDevice: "scaler", PortType: "HDMI", Tags: []string{"video", HDCPTag, MaxResolutionTag + "3840x2160", LatencyTag + "30ms"}},
+		},
+	},
+	structs.Device{
+		ID: "proj",
+		Ports: []structs.Port{
+			structs.Port{SourceDevice: "sw", ID: "hdbt1", DestinationDevice: "proj", PortType: "HDBaseT", Tags: []string{"video", HDCPTag, MaxResolutionTag + "1080p"}},
+			structs.Port{SourceDevice: "scaler", ID: "hdmi1", DestinationDevice: "proj", PortType: "HDMI", Tags: []string{"video", HDCPTag}},
+		},
+	},
+}
+
+func TestCapableReachability(t *testing.T) {
+	graph, err := BuildGraph(capabilityDevices, "video")
+	if err != nil {
+		t.Fatalf("failed to build graph: %s", err)
+	}
+
+	// any path works, so take the one without the scaler's latency
+	ok, path, rejections, err := CheckCapableReachability("proj", "pc", Requirements{HDCP: true}, graph)
+	if err != nil {
+		t.Fatalf("failed to check reachability: %s", err)
+	}
+
+	if !ok || len(path.Path) != 3 || len(rejections) != 0 {
+		t.Fatalf("expected the direct hdbaset path, got %+v (rejections: %+v)", path, rejections)
+	}
+
+	// only the scaler can carry 4k
+	ok, path, rejections, err = CheckCapableReachability("proj", "pc", Requirements{MinResolution: resolutionAliases["4k"]}, graph)
+	if err != nil {
+		t.Fatalf("failed to check reachability: %s", err)
+	}
+
+	if !ok || len(path.Path) != 4 || path.Latency != 30*time.Millisecond {
+		t.Fatalf("expected the path through the scaler, got %+v", path)
+	}
+
+	if len(rejections) != 1 || !strings.Contains(rejections[0].Reason, "1920x1080") {
+		t.Fatalf("expected the hdbaset path to be rejected for its resolution, got %+v", rejections)
+	}
+
+	// nothing is fast enough for 4k
+	ok, _, rejections, err = CheckCapableReachability("proj", "pc", Requirements{MinResolution: resolutionAliases["4k"], MaxLatency: 10 * time.Millisecond}, graph)
+	if err != nil {
+		t.Fatalf("failed to check reachability: %s", err)
+	}
+
+	if ok || len(rejections) != 2 {
+		t.Fatalf("expected both paths to be rejected, got %+v", rejections)
+	}
+
+	ok, _, _, err = CheckCapableReachability("proj", "pc", Requirements{PortTypes: []string{"hdbaset"}}, graph)
+	if err != nil {
+		t.Fatalf("failed to check reachability: %s", err)
+	}
+
+	if ok {
+		t.Fatalf("no path is hdbaset the whole way")
+	}
+}
+
+func TestParseResolution(t *testing.T) {
+	res, err := ParseResolution("1920x1200")
+	if err != nil || res.Width != 1920 || res.Height != 1200 {
+		t.Fatalf("failed to parse resolution: %+v, %v", res, err)
+	}
+
+	if _, err := ParseResolution("big"); err == nil {
+		t.Fatalf("expected an error parsing an invalid resolution")
+	}
+}
+
+func TestCapableMirrorReachability(t *testing.T) {
+	devices := []structs.Device{
+		structs.Device{ID: "pc"},
+		structs.Device{
+			ID:    "d1",
+			Roles: []structs.Role{mirrorMaster},
+			Ports: []structs.Port{
+				structs.Port{SourceDevice: "pc", ID: "hdmi1", DestinationDevice: "d1", PortType: "HDMI", Tags: []string{"video", HDCPTag}},
+				structs.Port{ID: MirrorPortID, DestinationDevice: "d2"},
+			},
+		},
+		structs.Device{ID: "d2"},
+	}
+
+	graph, err := BuildGraph(devices, "video")
+	if err != nil {
+		t.Fatalf("failed to build graph: %s", err)
+	}
+
+	ok, path, rejections, err := CheckCapableReachability("d2", "pc", Requirements{HDCP: true}, graph)
+	if err != nil {
+		t.Fatalf("failed to check reachability: %s", err)
+	}
+
+	if !ok || len(path.Path) != 3 || len(rejections) != 0 {
+		t.Fatalf("expected the mirror to inherit hdcp from d1, got %+v (rejections: %+v)", path, rejections)
+	}
+
+	if mirror := path.Edges[1]; !mirror.HDCP || mirror.PortType != "hdmi" {
+		t.Fatalf("expected the mirror connection to have d1's capabilities, got %+v", mirror)
+	}
+}
@@ -15,6 +15,9 @@ type InputGraph struct {
 	Nodes        []*Node
 	AdjacencyMap map[string][]string
 	DeviceMap    map[string]*Node
+
+	// Edges holds every connection into a device, keyed by the destination device
+	Edges map[string][]Edge
 }
 
 type Node struct {
@@ -29,12 +32,32 @@ type ReachableRoomConfig struct {
 
 var debug = true
 
+// MirrorPortID is the ID of the port a MirrorMaster uses to point at a device it mirrors
+const MirrorPortID = "mirror"
+
+// BuildGraph builds a graph of every connection between devs that carries the given signal type.
 func BuildGraph(devs []structs.Device, tag string) (InputGraph, error) {
-	log.L.Debugf("Building a %v signal path graph for room %v", tag, devs[0].ID)
+	return buildGraph(devs, tag, func(src, dest string) bool { return true })
+}
+
+// BuildPartitionGraph builds a graph like BuildGraph, but only includes connections between devices in different rooms if those rooms are combined in p.
+func BuildPartitionGraph(devs []structs.Device, tag string, p Partition) (InputGraph, error) {
+	return buildGraph(devs, tag, func(src, dest string) bool {
+		return p.Combined(structs.GetRoomIDFromDevice(src), structs.GetRoomIDFromDevice(dest))
+	})
+}
+
+func buildGraph(devs []structs.Device, tag string, include func(src, dest string) bool) (InputGraph, error) {
+	if len(devs) == 0 {
+		return InputGraph{}, fmt.Errorf("[inputgraph] No devices to build a %v signal path graph from", tag)
+	}
+
+	log.L.Debugf("Building a %v signal path graph for room %v", tag, devs[0].GetDeviceRoomID())
 	ig := InputGraph{
 		AdjacencyMap: make(map[string][]string),
 		DeviceMap:    make(map[string]*Node),
 		Nodes:        []*Node{},
+		Edges:        make(map[string][]Edge),
 	}
 
 	// build graph
@@ -51,57 +74,67 @@ func BuildGraph(devs []structs.Device, tag string) (InputGraph, error) {
 		// add each entry in the adjancy map
 		for _, port := range device.Ports {
 
+			// mirrored devices show whatever their master is showing
+			if port.ID == MirrorPortID && structs.HasRole(device, "MirrorMaster") {
+				if tag == "video" && include(device.ID, port.DestinationDevice) {
+					log.L.Debugf("[inputgraph] %v mirrors %v", port.DestinationDevice, device.ID)
+					ig.addEdge(port.DestinationDevice, device.ID, port)
+				}
+
+				continue
+			}
+
 			//if nothing is there, we default to video ports
 			if !structs.ContainsAnyTags(port.Tags, tag) && !(tag == "video" && len(port.Tags) == 0) {
 				continue
 			}
 
-			log.L.Debugf("[inputgraph] Adding %v to the adjecency for %v based on port %v", port.SourceDevice, port.DestinationDevice, port.ID)
-
-			if _, ok := ig.AdjacencyMap[port.DestinationDevice]; ok {
-				// only insert source device if it doesn't already exist
-				exists := false
-				for _, source := range ig.AdjacencyMap[port.DestinationDevice] {
-					if strings.EqualFold(source, port.SourceDevice) {
-						exists = true
-						break
-					}
-				}
-
-				if !exists {
-					ig.AdjacencyMap[port.DestinationDevice] = append(ig.AdjacencyMap[port.DestinationDevice], port.SourceDevice)
-				}
-			} else {
-				ig.AdjacencyMap[port.DestinationDevice] = []string{port.SourceDevice}
+			if !include(port.SourceDevice, port.DestinationDevice) {
+				log.L.Debugf("[inputgraph] Skipping port %v from %v to %v; the rooms are divided", port.ID, port.SourceDevice, port.DestinationDevice)
+				continue
 			}
 
+			log.L.Debugf("[inputgraph] Adding %v to the adjecency for %v based on port %v", port.SourceDevice, port.DestinationDevice, port.ID)
+			ig.addEdge(port.DestinationDevice, port.SourceDevice, port)
+
 			if structs.HasRole(device, "NetworkSwitch") {
 				//network swtich ports are bi-drectional, so we need to go the other direction here, too.
 				log.L.Debugf("[inputgraph] Network swtich, adding ports as bi-directional")
 				log.L.Debugf("[inputgraph] Adding %v to the adjecency for %v based on port %v", port.DestinationDevice, port.SourceDevice, port.ID)
+				ig.addEdge(port.SourceDevice, port.DestinationDevice, port)
+			}
+		}
+	}
 
-				if _, ok := ig.AdjacencyMap[port.SourceDevice]; ok {
-					// only insert source device if it doesn't already exist
-					exists := false
-					for _, source := range ig.AdjacencyMap[port.SourceDevice] {
-						if strings.EqualFold(source, port.DestinationDevice) {
-							exists = true
-							break
-						}
-					}
+	return ig, nil
+}
 
-					if !exists {
-						ig.AdjacencyMap[port.SourceDevice] = append(ig.AdjacencyMap[port.SourceDevice], port.DestinationDevice)
-					}
-				} else {
-					ig.AdjacencyMap[port.SourceDevice] = []string{port.DestinationDevice}
-				}
+// addEdge adds src to the adjacency list for dest, if it isn't already there, and records the port connecting them
+func (ig *InputGraph) addEdge(dest, src string, port structs.Port) {
+	exists := false
+	for _, edge := range ig.Edges[dest] {
+		if edge.Source == src && edge.Port == port.ID {
+			exists = true
+			break
+		}
+	}
 
-			}
+	if !exists {
+		ig.Edges[dest] = append(ig.Edges[dest], Edge{
+			Port:         port.ID,
+			Source:       src,
+			Destination:  dest,
+			Capabilities: GetCapabilities(port),
+		})
+	}
+
+	for _, source := range ig.AdjacencyMap[dest] {
+		if strings.EqualFold(source, src) {
+			return
 		}
 	}
 
-	return ig, nil
+	ig.AdjacencyMap[dest] = append(ig.AdjacencyMap[dest], src)
 }
 
 //where deviceA is the sink and deviceB is the SourceDevice
@@ -198,61 +231,105 @@ func CheckReachability(deviceA, deviceB string, ig InputGraph) (bool, []Node, er
 //There is a more effient way to do this as part of the initial traversal.
 //TODO: Make this more efficient.
 func GetVideoDeviceReachability(room structs.Room) (ReachableRoomConfig, *nerr.E) {
-
-	reachabilityMap := make(map[string][]string)
-
 	graph, err := BuildGraph(room.Devices, "video")
 	if err != nil {
 		return ReachableRoomConfig{Room: room}, nerr.Translate(err).Addf("Couldn't build reachability graph")
 	}
 	log.L.Debugf("%+v", graph.AdjacencyMap)
 
+	return ReachableRoomConfig{Room: room, InputReachability: getVideoReachability(room.ID, room.Devices, graph)}, nil
+}
+
+// GetPartitionedVideoDeviceReachability computes the reachability for each room in a divisible space, keyed by room ID.
+// Inputs can only reach outputs in another room if the two rooms are combined in p. Devices from another room are identified by their full ID.
+func GetPartitionedVideoDeviceReachability(rooms []structs.Room, p Partition) (map[string]ReachableRoomConfig, *nerr.E) {
+	devs := []structs.Device{}
+	for _, room := range rooms {
+		devs = append(devs, room.Devices...)
+	}
+
+	graph, err := BuildPartitionGraph(devs, "video", p)
+	if err != nil {
+		return nil, nerr.Translate(err).Addf("Couldn't build reachability graph")
+	}
+
+	configs := make(map[string]ReachableRoomConfig)
+	for _, room := range rooms {
+		// a room can use any device in a room it's combined with
+		combined := []structs.Device{}
+		for _, other := range rooms {
+			if p.Combined(room.ID, other.ID) {
+				combined = append(combined, other.Devices...)
+			}
+		}
+
+		configs[room.ID] = ReachableRoomConfig{Room: room, InputReachability: getVideoReachability(room.ID, combined, graph)}
+	}
+
+	return configs, nil
+}
+
+func getVideoReachability(roomID string, devs []structs.Device, graph InputGraph) map[string][]string {
+	reachabilityMap := make(map[string][]string)
+
 	log.L.Debugf("Building reachability map...")
 
 	inputs := []string{}
 	outputs := []string{}
 
-	for _, device := range room.Devices {
+	for _, device := range devs {
 		if structs.HasRole(device, "VideoIn") {
-			inputs = append(inputs, device.Name)
+			inputs = append(inputs, device.ID)
 		}
 		if structs.HasRole(device, "VideoOut") {
-			outputs = append(outputs, device.Name)
-
-			////////////////////////
-			///// MIRROR STUFF /////
-			if structs.HasRole(device, "MirrorMaster") {
-				for _, port := range device.Ports {
-					if port.ID == "mirror" {
-						log.L.Debugf("WE ARE HERE! WE ARE HERE! - %s", port.DestinationDevice)
-						name := strings.Split(port.DestinationDevice, "-")[2]
-						outputs = append(outputs, name)
-					}
-				}
-			}
-			///// MIRROR STUFF /////
-			////////////////////////
+			outputs = append(outputs, device.ID)
+			outputs = append(outputs, GetMirrors(device)...)
 		}
 	}
 
 	for _, i := range outputs {
 		for _, j := range inputs {
 			//check if the input can reach the output
-			reachable, _, err := CheckReachability(fmt.Sprintf("%v-%v", room.ID, i), fmt.Sprintf("%v-%v", room.ID, j), graph)
+			reachable, _, err := CheckReachability(i, j, graph)
 			if err != nil {
-				log.L.Warn("Couldn't calculate reachability between %v and %v", i, j)
+				log.L.Warnf("Couldn't calculate reachability between %v and %v", i, j)
 				continue
 			}
 			if reachable {
-				_, ok := reachabilityMap[j]
-				if ok {
-					reachabilityMap[j] = append(reachabilityMap[j], i)
-				} else {
-					reachabilityMap[j] = []string{i}
-				}
+				input := graph.shortName(roomID, j)
+				reachabilityMap[input] = append(reachabilityMap[input], graph.shortName(roomID, i))
 			}
 		}
 	}
 
-	return ReachableRoomConfig{Room: room, InputReachability: reachabilityMap}, nil
+	return reachabilityMap
+}
+
+// GetMirrors returns the IDs of the devices that a MirrorMaster device mirrors its output to.
+func GetMirrors(device structs.Device) []string {
+	mirrors := []string{}
+	if !structs.HasRole(device, "MirrorMaster") {
+		return mirrors
+	}
+
+	for _, port := range device.Ports {
+		if port.ID == MirrorPortID && len(port.DestinationDevice) > 0 {
+			mirrors = append(mirrors, port.DestinationDevice)
+		}
+	}
+
+	return mirrors
+}
+
+// shortName returns the name of a device in roomID, or its full ID if it's in a different room
+func (ig InputGraph) shortName(roomID, id string) string {
+	if structs.GetRoomIDFromDevice(id) != roomID {
+		return id
+	}
+
+	if node, ok := ig.DeviceMap[id]; ok && len(node.Device.Name) > 0 {
+		return node.Device.Name
+	}
+
+	return strings.TrimPrefix(id, roomID+"-")
 }
@@ -200,7 +200,7 @@ func TestGraphBuilding(t *testing.T) {
 
 	debug = false
 
-	graph, err := BuildGraph(Devices)
+	graph, err := BuildGraph(Devices, "video")
 	if err != nil {
 		log.L.Errorf("error: %v", err.Error())
 		t.FailNow()
@@ -213,7 +213,7 @@ func TestGraphBuilding(t *testing.T) {
 
 func TestReachability(t *testing.T) {
 
-	graph, err := BuildGraph(Devices)
+	graph, err := BuildGraph(Devices, "video")
 	if err != nil {
 		log.L.Infof("error: %v", err.Error())
 		t.FailNow()
@@ -0,0 +1,107 @@
+package inputgraph
+
+import "strings"
+
+// Partition is the divide state of a set of divisible rooms. Each group is a set of room IDs that are currently combined; a room that isn't in any group stands alone.
+type Partition struct {
+	Groups [][]string `json:"groups"`
+}
+
+// Combined returns true if roomA and roomB are the same room, or if they are currently combined.
+func (p Partition) Combined(roomA, roomB string) bool {
+	if strings.EqualFold(roomA, roomB) {
+		return true
+	}
+
+	group := p.group(roomA)
+	if group < 0 {
+		return false
+	}
+
+	return group == p.group(roomB)
+}
+
+// CombinedWith returns every room that room is currently combined with, including itself.
+func (p Partition) CombinedWith(room string) []string {
+	group := p.group(room)
+	if group < 0 {
+		return []string{room}
+	}
+
+	return append([]string{}, p.Groups[group]...)
+}
+
+// Combine merges the given rooms, and any rooms they are already combined with, into one group.
+func (p *Partition) Combine(rooms ...string) {
+	merged := []string{}
+	groups := [][]string{}
+
+	for _, group := range p.Groups {
+		combine := false
+		for _, room := range rooms {
+			if containsRoom(group, room) {
+				combine = true
+				break
+			}
+		}
+
+		if combine {
+			merged = append(merged, group...)
+		} else {
+			groups = append(groups, group)
+		}
+	}
+
+	for _, room := range rooms {
+		if !containsRoom(merged, room) {
+			merged = append(merged, room)
+		}
+	}
+
+	if len(merged) > 1 {
+		groups = append(groups, merged)
+	}
+
+	p.Groups = groups
+}
+
+// Divide separates room from whatever rooms it is combined with.
+func (p *Partition) Divide(room string) {
+	groups := [][]string{}
+
+	for _, group := range p.Groups {
+		remaining := []string{}
+		for _, r := range group {
+			if !strings.EqualFold(r, room) {
+				remaining = append(remaining, r)
+			}
+		}
+
+		// a group of one isn't combined with anything
+		if len(remaining) > 1 {
+			groups = append(groups, remaining)
+		}
+	}
+
+	p.Groups = groups
+}
+
+func (p Partition) group(room string) int {
+	for i, group := range p.Groups {
+		if containsRoom(group, room) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func containsRoom(rooms []string, room string) bool {
+	for _, r := range rooms {
+		if strings.EqualFold(r, room) {
+			return true
+		}
+	}
+
+	return false
+}
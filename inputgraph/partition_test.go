@@ -0,0 +1,101 @@
+package inputgraph
+
+import (
+	"testing"
+
+	"github.com/byuoitav/common/structs"
+)
+
+var videoIn = structs.Role{ID: "VideoIn"}
+var videoOut = structs.Role{ID: "VideoOut"}
+var mirrorMaster = structs.Role{ID: "MirrorMaster"}
+
+var room1 = structs.Room{
+	ID: "ITB-1101",
+	Devices: []structs.Device{
+		structs.Device{ID: "ITB-1101-HDMI1", Name: "HDMI1", Roles: []structs.Role{videoIn}},
+		structs.Device{
+			ID:   "ITB-1101-SW1",
+			Name: "SW1",
+			Ports: []structs.Port{
+				structs.Port{SourceDevice: "ITB-1101-HDMI1", ID: "IN1", DestinationDevice: "ITB-1101-SW1"},
+				structs.Port{SourceDevice: "ITB-1102-HDMI1", ID: "IN2", DestinationDevice: "ITB-1101-SW1"},
+			},
+		},
+		structs.Device{
+			ID:    "ITB-1101-D1",
+			Name:  "D1",
+			Roles: []structs.Role{videoOut, mirrorMaster},
+			Ports: []structs.Port{
+				structs.Port{SourceDevice: "ITB-1101-SW1", ID: "HDMI1", DestinationDevice: "ITB-1101-D1"},
+				structs.Port{ID: MirrorPortID, DestinationDevice: "ITB-1101-D2"},
+			},
+		},
+		structs.Device{ID: "ITB-1101-D2", Name: "D2"},
+	},
+}
+
+var room2 = structs.Room{
+	ID: "ITB-1102",
+	Devices: []structs.Device{
+		structs.Device{ID: "ITB-1102-HDMI1", Name: "HDMI1", Roles: []structs.Role{videoIn}},
+		structs.Device{
+			ID:    "ITB-1102-D1",
+			Name:  "D1",
+			Roles: []structs.Role{videoOut},
+			Ports: []structs.Port{
+				structs.Port{SourceDevice: "ITB-1101-SW1", ID: "HDMI1", DestinationDevice: "ITB-1102-D1"},
+			},
+		},
+	},
+}
+
+func TestMirrorReachability(t *testing.T) {
+	config, err := GetVideoDeviceReachability(room1)
+	if err != nil {
+		t.Fatalf("failed to get reachability: %s", err)
+	}
+
+	outputs := config.InputReachability["HDMI1"]
+	if len(outputs) != 2 || outputs[0] != "D1" || outputs[1] != "D2" {
+		t.Fatalf("expected HDMI1 to reach D1 and its mirror D2, got %v", outputs)
+	}
+}
+
+func TestPartitionedReachability(t *testing.T) {
+	rooms := []structs.Room{room1, room2}
+
+	configs, err := GetPartitionedVideoDeviceReachability(rooms, Partition{})
+	if err != nil {
+		t.Fatalf("failed to get reachability: %s", err)
+	}
+
+	if _, ok := configs["ITB-1101"].InputReachability["ITB-1102-HDMI1"]; ok {
+		t.Fatalf("ITB-1102-HDMI1 shouldn't be reachable while the rooms are divided: %v", configs["ITB-1101"].InputReachability)
+	}
+
+	if len(configs["ITB-1102"].InputReachability) != 0 {
+		t.Fatalf("nothing should be reachable in ITB-1102 while the rooms are divided: %v", configs["ITB-1102"].InputReachability)
+	}
+
+	p := Partition{}
+	p.Combine("ITB-1101", "ITB-1102")
+
+	configs, err = GetPartitionedVideoDeviceReachability(rooms, p)
+	if err != nil {
+		t.Fatalf("failed to get reachability: %s", err)
+	}
+
+	if len(configs["ITB-1101"].InputReachability["ITB-1102-HDMI1"]) != 3 {
+		t.Fatalf("ITB-1102-HDMI1 should reach every display while the rooms are combined: %v", configs["ITB-1101"].InputReachability)
+	}
+
+	if len(configs["ITB-1102"].InputReachability["HDMI1"]) != 3 {
+		t.Fatalf("HDMI1 should reach every display while the rooms are combined: %v", configs["ITB-1102"].InputReachability)
+	}
+
+	p.Divide("ITB-1102")
+	if p.Combined("ITB-1101", "ITB-1102") || len(p.Groups) != 0 {
+		t.Fatalf("rooms should be divided: %+v", p)
+	}
+}
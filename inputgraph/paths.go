@@ -0,0 +1,326 @@
+package inputgraph
+
+import (
+	"fmt"
+
+	"github.com/byuoitav/common/log"
+)
+
+// Redundancy describes how well a source device is connected to a sink device.
+type Redundancy struct {
+	Sink   string `json:"sink"`
+	Source string `json:"source"`
+
+	// Paths is every simple path from the source to the sink, up to the limit requested
+	Paths [][]Node `json:"paths"`
+
+	// Truncated is true if there were more paths than the limit allowed
+	Truncated bool `json:"truncated"`
+
+	// EdgeDisjointPaths is the largest set of paths that don't share a single connection
+	EdgeDisjointPaths [][]Node `json:"edge-disjoint-paths"`
+
+	// SinglePointsOfFailure are the devices whose failure would disconnect the source from the sink
+	SinglePointsOfFailure []Node `json:"single-points-of-failure"`
+}
+
+// GetRedundancy computes all of the paths, the edge-disjoint paths, and the single points of failure between deviceA (the sink) and deviceB (the source). limit caps the number of simple paths returned; a limit <= 0 means no limit.
+func GetRedundancy(deviceA, deviceB string, limit int, ig InputGraph) (Redundancy, error) {
+	r := Redundancy{
+		Sink:   deviceA,
+		Source: deviceB,
+	}
+
+	var err error
+
+	r.Paths, r.Truncated, err = GetAllPaths(deviceA, deviceB, limit, ig)
+	if err != nil {
+		return r, err
+	}
+
+	r.EdgeDisjointPaths, err = GetEdgeDisjointPaths(deviceA, deviceB, ig)
+	if err != nil {
+		return r, err
+	}
+
+	r.SinglePointsOfFailure, err = GetSinglePointsOfFailure(deviceA, deviceB, ig)
+	if err != nil {
+		return r, err
+	}
+
+	return r, nil
+}
+
+// GetAllPaths returns every simple path from deviceB (the source) to deviceA (the sink), in the same order CheckReachability returns them.
+// At most limit paths are returned; a limit <= 0 means no limit. The bool returned is true if there were paths left over when the limit was hit.
+func GetAllPaths(deviceA, deviceB string, limit int, ig InputGraph) ([][]Node, bool, error) {
+	if err := ig.checkDevices(deviceA, deviceB); err != nil {
+		return [][]Node{}, false, err
+	}
+
+	log.L.Debugf("[inputgraph] Enumerating all paths from %v to %v", deviceA, deviceB)
+
+	paths := [][]Node{}
+	truncated := false
+
+	onPath := map[string]bool{deviceA: true}
+	stack := []string{deviceA}
+
+	var walk func(cur string)
+	walk = func(cur string) {
+		if truncated {
+			return
+		}
+
+		if cur == deviceB {
+			if limit > 0 && len(paths) >= limit {
+				truncated = true
+				return
+			}
+
+			paths = append(paths, ig.nodes(reverse(stack)))
+			return
+		}
+
+		for _, next := range ig.AdjacencyMap[cur] {
+			if onPath[next] {
+				continue
+			}
+
+			onPath[next] = true
+			stack = append(stack, next)
+
+			walk(next)
+
+			stack = stack[:len(stack)-1]
+			delete(onPath, next)
+		}
+	}
+
+	walk(deviceA)
+
+	log.L.Debugf("[inputgraph] Found %v paths from %v to %v (truncated: %v)", len(paths), deviceA, deviceB, truncated)
+	return paths, truncated, nil
+}
+
+// GetEdgeDisjointPaths returns the largest set of paths from deviceB (the source) to deviceA (the sink) where no two paths share a connection.
+// The number of paths returned is the number of connections that have to fail before the source can't reach the sink.
+func GetEdgeDisjointPaths(deviceA, deviceB string, ig InputGraph) ([][]Node, error) {
+	if err := ig.checkDevices(deviceA, deviceB); err != nil {
+		return [][]Node{}, err
+	}
+
+	if deviceA == deviceB {
+		return [][]Node{ig.nodes([]string{deviceA})}, nil
+	}
+
+	capacity := func(from, to string) int {
+		for _, next := range ig.AdjacencyMap[from] {
+			if next == to {
+				return 1
+			}
+		}
+		return 0
+	}
+
+	// flow[from][to] is how many paths use the connection from -> to
+	flow := make(map[string]map[string]int)
+	getFlow := func(from, to string) int {
+		return flow[from][to]
+	}
+	addFlow := func(from, to string, n int) {
+		if _, ok := flow[from]; !ok {
+			flow[from] = make(map[string]int)
+		}
+		flow[from][to] += n
+	}
+
+	// neighbors in the residual graph are the outgoing connections, plus any connection we could push flow back across
+	residual := func(cur string) []string {
+		next := append([]string{}, ig.AdjacencyMap[cur]...)
+		for from, tos := range flow {
+			if tos[cur] > 0 {
+				next = append(next, from)
+			}
+		}
+		return next
+	}
+
+	// edmonds-karp; every connection has a capacity of one
+	for {
+		prev := map[string]string{}
+		visited := map[string]bool{deviceA: true}
+		queue := []string{deviceA}
+
+		for len(queue) > 0 && !visited[deviceB] {
+			cur := queue[0]
+			queue = queue[1:]
+
+			for _, next := range residual(cur) {
+				if visited[next] {
+					continue
+				}
+
+				if capacity(cur, next)-getFlow(cur, next)+getFlow(next, cur) <= 0 {
+					continue
+				}
+
+				visited[next] = true
+				prev[next] = cur
+				queue = append(queue, next)
+			}
+		}
+
+		if !visited[deviceB] {
+			break
+		}
+
+		// augment along the path we found
+		for cur := deviceB; cur != deviceA; cur = prev[cur] {
+			from := prev[cur]
+			if getFlow(cur, from) > 0 {
+				addFlow(cur, from, -1)
+			} else {
+				addFlow(from, cur, 1)
+			}
+		}
+	}
+
+	// decompose the flow into paths
+	paths := [][]Node{}
+	for {
+		path := []string{deviceA}
+		index := map[string]int{deviceA: 0}
+		cur := deviceA
+
+		for cur != deviceB {
+			next := ""
+			for to, n := range flow[cur] {
+				if n > 0 {
+					next = to
+					break
+				}
+			}
+
+			if next == "" {
+				break
+			}
+
+			addFlow(cur, next, -1)
+
+			// drop any cycles the flow picked up
+			if i, ok := index[next]; ok {
+				for _, id := range path[i+1:] {
+					delete(index, id)
+				}
+				path = path[:i+1]
+			} else {
+				index[next] = len(path)
+				path = append(path, next)
+			}
+
+			cur = next
+		}
+
+		if cur != deviceB {
+			break
+		}
+
+		paths = append(paths, ig.nodes(reverse(path)))
+	}
+
+	log.L.Debugf("[inputgraph] Found %v edge-disjoint paths from %v to %v", len(paths), deviceA, deviceB)
+	return paths, nil
+}
+
+// GetSinglePointsOfFailure returns the devices between deviceB (the source) and deviceA (the sink) that every path goes through, ordered from the source to the sink.
+// If deviceB can't reach deviceA (or is directly connected to it), no devices are returned.
+func GetSinglePointsOfFailure(deviceA, deviceB string, ig InputGraph) ([]Node, error) {
+	if err := ig.checkDevices(deviceA, deviceB); err != nil {
+		return []Node{}, err
+	}
+
+	path := ig.findPath(deviceA, deviceB, nil)
+	if len(path) < 3 {
+		return []Node{}, nil
+	}
+
+	// every single point of failure has to be on every path, so we only need to check the devices on one of them
+	failures := []string{}
+	for _, id := range path[1 : len(path)-1] {
+		if len(ig.findPath(deviceA, deviceB, map[string]bool{id: true})) == 0 {
+			log.L.Debugf("[inputgraph] %v is a single point of failure between %v and %v", id, deviceB, deviceA)
+			failures = append(failures, id)
+		}
+	}
+
+	return ig.nodes(reverse(failures)), nil
+}
+
+// findPath does a BFS from the sink to the source, skipping any device in excluded. It returns the path from the sink to the source, or nil if there isn't one.
+func (ig InputGraph) findPath(sink, source string, excluded map[string]bool) []string {
+	prev := map[string]string{}
+	visited := map[string]bool{sink: true}
+	queue := []string{sink}
+
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+
+		if cur == source {
+			path := []string{cur}
+			for cur != sink {
+				cur = prev[cur]
+				path = append(path, cur)
+			}
+
+			return reverse(path)
+		}
+
+		for _, next := range ig.AdjacencyMap[cur] {
+			if visited[next] || excluded[next] {
+				continue
+			}
+
+			visited[next] = true
+			prev[next] = cur
+			queue = append(queue, next)
+		}
+	}
+
+	return nil
+}
+
+// checkDevices makes sure that each of the devices are a part of the graph
+func (ig InputGraph) checkDevices(ids ...string) error {
+	for _, id := range ids {
+		if _, ok := ig.DeviceMap[id]; !ok {
+			return fmt.Errorf("[inputgraph] Device %v is not part of the graph", id)
+		}
+	}
+
+	return nil
+}
+
+// nodes looks up the node for each id. Devices that are only referenced by a port get an empty node.
+func (ig InputGraph) nodes(ids []string) []Node {
+	nodes := make([]Node, 0, len(ids))
+	for _, id := range ids {
+		if node, ok := ig.DeviceMap[id]; ok {
+			nodes = append(nodes, *node)
+		} else {
+			nodes = append(nodes, Node{ID: id})
+		}
+	}
+
+	return nodes
+}
+
+func reverse(ids []string) []string {
+	reversed := make([]string, len(ids))
+	for i := range ids {
+		reversed[len(ids)-1-i] = ids[i]
+	}
+
+	return reversed
+}
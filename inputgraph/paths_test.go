@@ -0,0 +1,125 @@
+package inputgraph
+
+import (
+	"testing"
+
+	"github.com/byuoitav/common/structs"
+)
+
+// src -> sw1 -> dsp -> disp1
+// src -> sw2 -> dsp -> disp1
+// src -> sw1 -> disp2
+// src -> sw2 -> disp2
+var redundancyDevices = []structs.Device{
+	structs.Device{ID: "src"},
+	structs.Device{
+		ID: "sw1",
+		Ports: []structs.Port{
+			structs.Port{SourceDevice: "src", ID: "in1", DestinationDevice: "sw1"},
+		},
+	},
+	structs.Device{
+		ID: "sw2",
+		Ports: []structs.Port{
+			structs.Port{SourceDevice: "src", ID: "in1", DestinationDevice: "sw2"},
+		},
+	},
+	structs.Device{
+		ID: "dsp",
+		Ports: []structs.Port{
+			structs.Port{SourceDevice: "sw1", ID: "in1", DestinationDevice: "dsp"},
+			structs.Port{SourceDevice: "sw2", ID: "in2", DestinationDevice: "dsp"},
+		},
+	},
+	structs.Device{
+		ID: "disp1",
+		Ports: []structs.Port{
+			structs.Port{SourceDevice: "dsp", ID: "in1", DestinationDevice: "disp1"},
+		},
+	},
+	structs.Device{
+		ID: "disp2",
+		Ports: []structs.Port{
+			structs.Port{SourceDevice: "sw1", ID: "in1", DestinationDevice: "disp2"},
+			structs.Port{SourceDevice: "sw2", ID: "in2", DestinationDevice: "disp2"},
+		},
+	},
+}
+
+func TestGetAllPaths(t *testing.T) {
+	graph, err := BuildGraph(redundancyDevices, "video")
+	if err != nil {
+		t.Fatalf("failed to build graph: %s", err)
+	}
+
+	paths, truncated, err := GetAllPaths("disp1", "src", 0, graph)
+	if err != nil {
+		t.Fatalf("failed to get paths: %s", err)
+	}
+
+	if len(paths) != 2 || truncated {
+		t.Fatalf("expected 2 paths, got %v (truncated: %v)", len(paths), truncated)
+	}
+
+	for _, path := range paths {
+		if path[0].ID != "src" || path[len(path)-1].ID != "disp1" {
+			t.Fatalf("path should go from src to disp1: %+v", path)
+		}
+	}
+
+	paths, truncated, err = GetAllPaths("disp1", "src", 1, graph)
+	if err != nil {
+		t.Fatalf("failed to get paths: %s", err)
+	}
+
+	if len(paths) != 1 || !truncated {
+		t.Fatalf("expected 1 truncated path, got %v (truncated: %v)", len(paths), truncated)
+	}
+
+	_, _, err = GetAllPaths("disp1", "nope", 0, graph)
+	if err == nil {
+		t.Fatalf("expected an error for a device not in the graph")
+	}
+}
+
+func TestRedundancy(t *testing.T) {
+	graph, err := BuildGraph(redundancyDevices, "video")
+	if err != nil {
+		t.Fatalf("failed to build graph: %s", err)
+	}
+
+	r, err := GetRedundancy("disp1", "src", 0, graph)
+	if err != nil {
+		t.Fatalf("failed to get redundancy: %s", err)
+	}
+
+	if len(r.EdgeDisjointPaths) != 1 {
+		t.Fatalf("expected 1 edge-disjoint path to disp1, got %v", len(r.EdgeDisjointPaths))
+	}
+
+	if len(r.SinglePointsOfFailure) != 1 || r.SinglePointsOfFailure[0].ID != "dsp" {
+		t.Fatalf("expected dsp to be the only single point of failure, got %+v", r.SinglePointsOfFailure)
+	}
+
+	r, err = GetRedundancy("disp2", "src", 0, graph)
+	if err != nil {
+		t.Fatalf("failed to get redundancy: %s", err)
+	}
+
+	if len(r.EdgeDisjointPaths) != 2 {
+		t.Fatalf("expected 2 edge-disjoint paths to disp2, got %v", len(r.EdgeDisjointPaths))
+	}
+
+	if len(r.SinglePointsOfFailure) != 0 {
+		t.Fatalf("expected no single points of failure, got %+v", r.SinglePointsOfFailure)
+	}
+
+	r, err = GetRedundancy("src", "disp1", 0, graph)
+	if err != nil {
+		t.Fatalf("failed to get redundancy: %s", err)
+	}
+
+	if len(r.Paths) != 0 || len(r.EdgeDisjointPaths) != 0 || len(r.SinglePointsOfFailure) != 0 {
+		t.Fatalf("expected nothing to be reachable backwards, got %+v", r)
+	}
+}
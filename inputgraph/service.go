@@ -0,0 +1,229 @@
+package inputgraph
+
+import (
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/byuoitav/common/db"
+	"github.com/byuoitav/common/log"
+	"github.com/byuoitav/common/nerr"
+	"github.com/byuoitav/common/structs"
+)
+
+// DefaultSignalTypes are the signal types a Service builds graphs for if none are given.
+var DefaultSignalTypes = []string{"video", "audio"}
+
+// Service loads rooms out of the database, builds a graph for each signal type, and keeps them up to date as the devices in the room change.
+type Service struct {
+	db      db.DB
+	tags    []string
+	refresh time.Duration
+
+	rooms map[string]*roomGraphs
+	mu    sync.RWMutex
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+type roomGraphs struct {
+	devices []structs.Device
+	graphs  map[string]InputGraph
+}
+
+// NewService creates a Service that builds graphs for the given signal types (or DefaultSignalTypes). Every refresh, each room that has been built is reloaded from the database, and its graphs are rebuilt if its devices have changed.
+// A refresh <= 0 disables reloading; use Invalidate or Refresh to pick up changes instead.
+func NewService(database db.DB, refresh time.Duration, tags ...string) *Service {
+	if len(tags) == 0 {
+		tags = DefaultSignalTypes
+	}
+
+	s := &Service{
+		db:      database,
+		tags:    tags,
+		refresh: refresh,
+		rooms:   make(map[string]*roomGraphs),
+		stop:    make(chan struct{}),
+	}
+
+	if refresh > 0 {
+		go s.watch()
+	}
+
+	return s
+}
+
+// Stop stops reloading rooms from the database.
+func (s *Service) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+	})
+}
+
+// GetGraph returns the graph of the given signal type for a room, building it if it hasn't been built yet.
+func (s *Service) GetGraph(roomID, tag string) (InputGraph, *nerr.E) {
+	rg, err := s.get(roomID)
+	if err != nil {
+		return InputGraph{}, err
+	}
+
+	graph, ok := rg.graphs[tag]
+	if !ok {
+		return InputGraph{}, nerr.Createf("error", "no %v signal path graph for room %v", tag, roomID)
+	}
+
+	return graph, nil
+}
+
+// CheckReachability checks if source can reach sink in roomID's graph of the given signal type. See CheckReachability.
+func (s *Service) CheckReachability(roomID, tag, sink, source string) (bool, []Node, *nerr.E) {
+	graph, err := s.GetGraph(roomID, tag)
+	if err != nil {
+		return false, []Node{}, err
+	}
+
+	reachable, path, er := CheckReachability(sink, source, graph)
+	if er != nil {
+		return false, []Node{}, nerr.Translate(er).Addf("unable to check reachability in %v", roomID)
+	}
+
+	return reachable, path, nil
+}
+
+// GetAllPaths returns every path from source to sink in roomID's graph of the given signal type. See GetAllPaths.
+func (s *Service) GetAllPaths(roomID, tag, sink, source string, limit int) ([][]Node, bool, *nerr.E) {
+	graph, err := s.GetGraph(roomID, tag)
+	if err != nil {
+		return [][]Node{}, false, err
+	}
+
+	paths, truncated, er := GetAllPaths(sink, source, limit, graph)
+	if er != nil {
+		return [][]Node{}, false, nerr.Translate(er).Addf("unable to get paths in %v", roomID)
+	}
+
+	return paths, truncated, nil
+}
+
+// GetRedundancy returns the redundancy between source and sink in roomID's graph of the given signal type. See GetRedundancy.
+func (s *Service) GetRedundancy(roomID, tag, sink, source string, limit int) (Redundancy, *nerr.E) {
+	graph, err := s.GetGraph(roomID, tag)
+	if err != nil {
+		return Redundancy{}, err
+	}
+
+	r, er := GetRedundancy(sink, source, limit, graph)
+	if er != nil {
+		return r, nerr.Translate(er).Addf("unable to get redundancy in %v", roomID)
+	}
+
+	return r, nil
+}
+
+// GetActiveVideoPaths traces what each display in roomID is currently showing, using the device states in the database. See GetActivePath.
+func (s *Service) GetActiveVideoPaths(roomID string) (map[string]ActivePath, *nerr.E) {
+	graph, err := s.GetGraph(roomID, "video")
+	if err != nil {
+		return nil, err
+	}
+
+	states, er := s.db.GetDeviceStatesByRoom(roomID)
+	if er != nil {
+		return nil, nerr.Translate(er).Addf("unable to get device states in %v", roomID)
+	}
+
+	paths, er := GetActivePaths(graph.GetVideoDisplays(), states, graph)
+	if er != nil {
+		return nil, nerr.Translate(er).Addf("unable to get active paths in %v", roomID)
+	}
+
+	return paths, nil
+}
+
+// Invalidate drops the graphs for a room, so that they are rebuilt from the database the next time they are used.
+func (s *Service) Invalidate(roomID string) {
+	s.mu.Lock()
+	delete(s.rooms, roomID)
+	s.mu.Unlock()
+}
+
+// Refresh reloads a room from the database now, and rebuilds its graphs if its devices have changed.
+func (s *Service) Refresh(roomID string) *nerr.E {
+	_, err := s.load(roomID)
+	return err
+}
+
+func (s *Service) get(roomID string) (*roomGraphs, *nerr.E) {
+	s.mu.RLock()
+	rg, ok := s.rooms[roomID]
+	s.mu.RUnlock()
+
+	if ok {
+		return rg, nil
+	}
+
+	return s.load(roomID)
+}
+
+func (s *Service) load(roomID string) (*roomGraphs, *nerr.E) {
+	devs, err := s.db.GetDevicesByRoom(roomID)
+	if err != nil {
+		return nil, nerr.Translate(err).Addf("unable to get devices in %v", roomID)
+	}
+
+	s.mu.RLock()
+	cur, ok := s.rooms[roomID]
+	s.mu.RUnlock()
+
+	if ok && reflect.DeepEqual(cur.devices, devs) {
+		return cur, nil
+	}
+
+	log.L.Infof("[inputgraph] Building signal path graphs for %v", roomID)
+
+	rg := &roomGraphs{
+		devices: devs,
+		graphs:  make(map[string]InputGraph),
+	}
+
+	for _, tag := range s.tags {
+		graph, err := BuildGraph(devs, tag)
+		if err != nil {
+			return nil, nerr.Translate(err).Addf("unable to build %v signal path graph for %v", tag, roomID)
+		}
+
+		rg.graphs[tag] = graph
+	}
+
+	s.mu.Lock()
+	s.rooms[roomID] = rg
+	s.mu.Unlock()
+
+	return rg, nil
+}
+
+func (s *Service) watch() {
+	ticker := time.NewTicker(s.refresh)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.mu.RLock()
+			rooms := make([]string, 0, len(s.rooms))
+			for roomID := range s.rooms {
+				rooms = append(rooms, roomID)
+			}
+			s.mu.RUnlock()
+
+			for _, roomID := range rooms {
+				if err := s.Refresh(roomID); err != nil {
+					log.L.Warnf("[inputgraph] Unable to refresh graphs for %v: %s", roomID, err.Error())
+				}
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package inputgraph
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/byuoitav/common/db"
+	"github.com/byuoitav/common/structs"
+)
+
+type fakeDB struct {
+	db.DB
+
+	devices map[string][]structs.Device
+	calls   int
+	mu      sync.Mutex
+}
+
+func (f *fakeDB) GetDevicesByRoom(roomID string) ([]structs.Device, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.calls++
+	return f.devices[roomID], nil
+}
+
+func (f *fakeDB) set(roomID string, devs []structs.Device) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.devices[roomID] = devs
+}
+
+func TestService(t *testing.T) {
+	fake := &fakeDB{devices: map[string][]structs.Device{
+		"ITB-1101": room1.Devices,
+	}}
+
+	s := NewService(fake, 0)
+	defer s.Stop()
+
+	reachable, _, err := s.CheckReachability("ITB-1101", "video", "ITB-1101-D2", "ITB-1101-HDMI1")
+	if err != nil {
+		t.Fatalf("failed to check reachability: %s", err)
+	}
+
+	if !reachable {
+		t.Fatalf("ITB-1101-HDMI1 should be able to reach ITB-1101-D2")
+	}
+
+	if _, err := s.GetGraph("ITB-1101", "audio"); err != nil {
+		t.Fatalf("failed to get audio graph: %s", err)
+	}
+
+	if fake.calls != 1 {
+		t.Fatalf("expected graphs to be cached, but the database was called %v times", fake.calls)
+	}
+
+	// unplug the switcher from the display
+	devs := append([]structs.Device{}, room1.Devices[:2]...)
+	devs = append(devs, structs.Device{ID: "ITB-1101-D1", Name: "D1", Roles: []structs.Role{videoOut}})
+	fake.set("ITB-1101", devs)
+
+	if err := s.Refresh("ITB-1101"); err != nil {
+		t.Fatalf("failed to refresh: %s", err)
+	}
+
+	reachable, _, err = s.CheckReachability("ITB-1101", "video", "ITB-1101-D1", "ITB-1101-HDMI1")
+	if err != nil {
+		t.Fatalf("failed to check reachability: %s", err)
+	}
+
+	if reachable {
+		t.Fatalf("ITB-1101-HDMI1 shouldn't be able to reach ITB-1101-D1 after it was unplugged")
+	}
+
+	if _, err := s.GetGraph("ITB-1102", "video"); err == nil {
+		t.Fatalf("expected an error building a graph for a room with no devices")
+	}
+}
@@ -0,0 +1,160 @@
+package pooled
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Backoff is how long to wait between failed attempts to open a connection for a key. The first retry waits Initial, and each retry after that waits Multiplier times longer, up to Max.
+type Backoff struct {
+	Initial    time.Duration
+	Max        time.Duration
+	Multiplier float64
+}
+
+// delay returns how long to wait after the given number of consecutive failures
+func (b Backoff) delay(failures int) time.Duration {
+	if b.Initial <= 0 || failures <= 0 {
+		return 0
+	}
+
+	multiplier := b.Multiplier
+	if multiplier < 1 {
+		multiplier = 2
+	}
+
+	delay := float64(b.Initial)
+	for i := 1; i < failures; i++ {
+		delay *= multiplier
+		if b.Max > 0 && delay >= float64(b.Max) {
+			return b.Max
+		}
+	}
+
+	return time.Duration(delay)
+}
+
+// CircuitBreaker stops a Map from trying to open connections to a key that is known to be down.
+// After Threshold consecutive failures, requests for the key fail right away with a *CircuitOpenError. Once Cooldown has passed, the next request is allowed to try again; if it succeeds the breaker is reset, otherwise it waits another Cooldown.
+// A Threshold <= 0 disables the breaker.
+type CircuitBreaker struct {
+	Threshold int
+	Cooldown  time.Duration
+}
+
+// CircuitOpenError is returned when a request is rejected because its key's circuit breaker is open.
+type CircuitOpenError struct {
+	Key interface{}
+
+	// Until is when the next attempt to open a connection will be allowed
+	Until time.Time
+
+	// Err is the error from the last attempt to open a connection
+	Err error
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("circuit breaker for %v is open until %v: %s", e.Key, e.Until.Format(time.RFC3339), e.Err)
+}
+
+// breaker tracks failed attempts to open a connection for a single key
+type breaker struct {
+	// sem is held while a connection is being opened
+	sem chan struct{}
+
+	mu       sync.Mutex
+	failures int
+	lastErr  error
+	next     time.Time
+	probing  bool
+}
+
+func newBreaker() *breaker {
+	return &breaker{
+		sem: make(chan struct{}, 1),
+	}
+}
+
+// check returns a *CircuitOpenError if the breaker is open, or if another request is already probing a half-open breaker
+func (b *breaker) check(key interface{}, cfg CircuitBreaker) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if cfg.Threshold <= 0 || b.failures < cfg.Threshold {
+		return nil
+	}
+
+	if time.Now().Before(b.next) || b.probing {
+		return &CircuitOpenError{Key: key, Until: b.next, Err: b.lastErr}
+	}
+
+	return nil
+}
+
+// start is called right before a connection is opened, and returns how long to wait before opening it
+func (b *breaker) start(cfg CircuitBreaker) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if cfg.Threshold > 0 && b.failures >= cfg.Threshold {
+		b.probing = true
+		return 0
+	}
+
+	if b.failures == 0 {
+		return 0
+	}
+
+	return time.Until(b.next)
+}
+
+func (b *breaker) failure(err error, backoff Backoff, cfg CircuitBreaker) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	b.lastErr = err
+	b.probing = false
+
+	if cfg.Threshold > 0 && b.failures >= cfg.Threshold {
+		b.next = time.Now().Add(cfg.Cooldown)
+		return
+	}
+
+	b.next = time.Now().Add(backoff.delay(b.failures))
+}
+
+func (b *breaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures = 0
+	b.lastErr = nil
+	b.next = time.Time{}
+	b.probing = false
+}
+
+// abort is called if a connection wasn't opened after start, without it failing
+func (b *breaker) abort() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+}
+
+// open returns true if the breaker is rejecting requests until its cooldown passes. A half-open breaker, which lets the next request try again, isn't open
+func (b *breaker) open(cfg CircuitBreaker) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return cfg.Threshold > 0 && b.failures >= cfg.Threshold && time.Now().Before(b.next)
+}
+
+// idle returns true if the breaker has nothing to remember, and nobody is opening a connection with it
+func (b *breaker) idle() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.failures == 0 && !b.probing && len(b.sem) == 0
+}
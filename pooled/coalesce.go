@@ -0,0 +1,128 @@
+package pooled
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/byuoitav/common/log"
+)
+
+// Query is work that only reads from a device, and returns what it read.
+type Query func(Conn) (interface{}, error)
+
+type flightKey struct {
+	key      interface{}
+	id       string
+	priority Priority
+}
+
+// flight is a coalesced query, shared by every caller that asked for it while it was queued
+type flight struct {
+	done chan struct{}
+	val  interface{}
+	err  error
+
+	// cancel abandons the query if nobody is waiting on it before it starts
+	cancel context.CancelFunc
+
+	// these are protected by the map's mutex
+	waiting int
+	started bool
+}
+
+// DoCoalesced runs query on the connection for key, like DoContext, and returns what it read.
+// If a query with the same id and priority is already queued for key, query isn't queued again; instead, the caller waits for the queued one and gets the same result. Once a query starts running, new callers queue a new one.
+// Only queries that don't change anything on the device should be coalesced, and id should identify what it reads (e.g. "power"). The shared query isn't limited by any one caller's deadline, but it's abandoned if every caller gives up on it before it starts.
+func (m *Map) DoCoalesced(ctx context.Context, key interface{}, id string, query Query) (interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, &TimeoutError{Key: key, Queued: true, Err: err}
+	}
+
+	fk := flightKey{key: key, id: id, priority: PriorityFromContext(ctx)}
+
+	m.mu.Lock()
+	f, ok := m.flights[fk]
+	if ok {
+		f.waiting++
+	} else {
+		fctx, cancel := context.WithCancel(ContextWithPriority(context.Background(), fk.priority))
+
+		f = &flight{
+			done:    make(chan struct{}),
+			cancel:  cancel,
+			waiting: 1,
+		}
+
+		m.flights[fk] = f
+		go m.fly(fctx, fk, f, query)
+	}
+	m.mu.Unlock()
+
+	if ok {
+		log.L.Named(fmt.Sprintf("%s", key)).Debugf("Coalescing %q with a request that is already queued", id)
+	}
+
+	select {
+	case <-f.done:
+		return f.val, f.err
+	case <-ctx.Done():
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		// once everyone has given up, new callers start a new flight, and a query that hasn't started is abandoned
+		f.waiting--
+		if f.waiting == 0 {
+			if cur, ok := m.flights[fk]; ok && cur == f {
+				delete(m.flights, fk)
+			}
+
+			if !f.started {
+				f.cancel()
+			}
+		}
+
+		return nil, &TimeoutError{Key: key, Queued: !f.started, Err: ctx.Err()}
+	}
+}
+
+// fly queues the query for a flight, and shares its result with everyone waiting on it
+func (m *Map) fly(ctx context.Context, fk flightKey, f *flight, query Query) {
+	defer f.cancel()
+
+	req := request{
+		ctx: ctx,
+		work: func(conn Conn) error {
+			var err error
+			f.val, err = query(conn)
+			return err
+		},
+		resp:     make(chan error, 1),
+		queued:   time.Now(),
+		priority: fk.priority,
+		started: func() {
+			m.land(fk, f)
+		},
+	}
+
+	err := m.send(ctx, fk.key, req)
+	if err == nil {
+		err = <-req.resp
+	}
+
+	m.land(fk, f)
+
+	f.err = err
+	close(f.done)
+}
+
+// land stops new callers from joining a flight
+func (m *Map) land(fk flightKey, f *flight) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f.started = true
+	if cur, ok := m.flights[fk]; ok && cur == f {
+		delete(m.flights, fk)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"sync"
 	"time"
 
 	"github.com/byuoitav/common/log"
@@ -14,31 +15,51 @@ import (
 // Conn .
 type Conn interface {
 	io.ReadWriter
+	io.Closer
 
 	Log() *zap.SugaredLogger
 	ReadWriter() *bufio.ReadWriter
+	Transport() Transport
+	SetDeadline(t time.Time) error
 	SetReadDeadline(t time.Time) error
 	SetWriteDeadline(t time.Time) error
+
+	// SetMaxDeadline keeps any deadline set on the connection from being later than t, and moves the current deadlines up to t. A zero t removes the limit.
+	SetMaxDeadline(t time.Time)
+
 	EmptyReadBuffer(timeout time.Duration) ([]byte, error)
 	ReadUntil(delim byte, timeout time.Duration) ([]byte, error)
-	netconn() net.Conn
 }
 
 type conn struct {
 	log  *zap.SugaredLogger
 	rw   *bufio.ReadWriter
-	conn net.Conn
+	conn Transport
+
+	// maxDeadline is the latest a read or write is allowed to block until
+	maxDeadline time.Time
+	deadlineMu  sync.Mutex
 }
 
 // Wrap .
 func Wrap(c net.Conn) Conn {
+	return WrapTransport(c.RemoteAddr().String(), c)
+}
+
+// WrapTransport wraps any transport (e.g. a TLS connection, or a telnet session from NewTelnetTransport) in a Conn. name is used for logging.
+func WrapTransport(name string, t Transport) Conn {
 	return &conn{
-		log:  log.L.Named(c.RemoteAddr().String()),
-		rw:   bufio.NewReadWriter(bufio.NewReader(c), bufio.NewWriter(c)),
-		conn: c,
+		log:  log.L.Named(name),
+		rw:   bufio.NewReadWriter(bufio.NewReader(t), bufio.NewWriter(t)),
+		conn: t,
 	}
 }
 
+// WrapStream wraps a stream without deadlines, like a serial port, in a Conn. See NewStreamTransport.
+func WrapStream(name string, rwc io.ReadWriteCloser) Conn {
+	return WrapTransport(name, NewStreamTransport(rwc))
+}
+
 func (c *conn) Log() *zap.SugaredLogger {
 	return c.log
 }
@@ -47,12 +68,43 @@ func (c *conn) ReadWriter() *bufio.ReadWriter {
 	return c.rw
 }
 
+func (c *conn) Transport() Transport {
+	return c.conn
+}
+
+func (c *conn) Close() error {
+	return c.conn.Close()
+}
+
+func (c *conn) SetDeadline(t time.Time) error {
+	return c.conn.SetDeadline(c.clamp(t))
+}
+
 func (c *conn) SetReadDeadline(t time.Time) error {
-	return c.conn.SetReadDeadline(t)
+	return c.conn.SetReadDeadline(c.clamp(t))
 }
 
 func (c *conn) SetWriteDeadline(t time.Time) error {
-	return c.conn.SetWriteDeadline(t)
+	return c.conn.SetWriteDeadline(c.clamp(t))
+}
+
+func (c *conn) SetMaxDeadline(t time.Time) {
+	c.deadlineMu.Lock()
+	c.maxDeadline = t
+	c.deadlineMu.Unlock()
+
+	c.conn.SetDeadline(t)
+}
+
+func (c *conn) clamp(t time.Time) time.Time {
+	c.deadlineMu.Lock()
+	defer c.deadlineMu.Unlock()
+
+	if !c.maxDeadline.IsZero() && (t.IsZero() || t.After(c.maxDeadline)) {
+		return c.maxDeadline
+	}
+
+	return t
 }
 
 func (c *conn) Write(p []byte) (int, error) {
@@ -69,7 +121,7 @@ func (c *conn) Read(p []byte) (int, error) {
 }
 
 func (c *conn) EmptyReadBuffer(timeout time.Duration) ([]byte, error) {
-	c.conn.SetReadDeadline(time.Now().Add(timeout))
+	c.SetReadDeadline(time.Now().Add(timeout))
 
 	total := c.rw.Reader.Buffered()
 	bytes := make([]byte, 0, total)
@@ -91,7 +143,3 @@ func (c *conn) ReadUntil(delim byte, timeout time.Duration) ([]byte, error) {
 	c.SetReadDeadline(time.Now().Add(timeout))
 	return c.rw.ReadBytes(delim)
 }
-
-func (c *conn) netconn() net.Conn {
-	return c.conn
-}
@@ -0,0 +1,35 @@
+package pooled
+
+import (
+	"context"
+	"fmt"
+)
+
+// TimeoutError is returned when a request's context is done before its work finishes.
+type TimeoutError struct {
+	Key interface{}
+
+	// Queued is true if the work never started
+	Queued bool
+
+	// Err is the error from the request's context
+	Err error
+}
+
+func (e *TimeoutError) Error() string {
+	if e.Queued {
+		return fmt.Sprintf("request for %v was abandoned before it started: %s", e.Key, e.Err)
+	}
+
+	return fmt.Sprintf("request for %v was abandoned while it was running: %s", e.Key, e.Err)
+}
+
+// Timeout returns true if the request's deadline passed, rather than being canceled.
+func (e *TimeoutError) Timeout() bool {
+	return e.Err == context.DeadlineExceeded
+}
+
+// Temporary always returns true; the request can be tried again.
+func (e *TimeoutError) Temporary() bool {
+	return true
+}
@@ -0,0 +1,262 @@
+package pooled
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Framer reads a single frame from a connection. Each of the framing functions has a matching Framer (e.g. Delimited for ReadUntilAny), so that frames can be read generically, like in Request.
+type Framer func(c Conn, timeout time.Duration) ([]byte, error)
+
+// Fixed reads frames that are always n bytes long.
+func Fixed(n int) Framer {
+	return func(c Conn, timeout time.Duration) ([]byte, error) {
+		return ReadN(c, n, timeout)
+	}
+}
+
+// Delimited reads frames that end with any of delims.
+func Delimited(delims ...byte) Framer {
+	return func(c Conn, timeout time.Duration) ([]byte, error) {
+		return ReadUntilAny(c, delims, timeout)
+	}
+}
+
+// Sequence reads frames that end with seq.
+func Sequence(seq []byte) Framer {
+	return func(c Conn, timeout time.Duration) ([]byte, error) {
+		return ReadUntilSequence(c, seq, timeout)
+	}
+}
+
+// LengthPrefixed reads frames that start with their length.
+func LengthPrefixed(prefix LengthPrefix) Framer {
+	return func(c Conn, timeout time.Duration) ([]byte, error) {
+		return ReadLengthPrefixed(c, prefix, timeout)
+	}
+}
+
+// Checksummed reads frames with f, and verifies their checksum.
+func Checksummed(f Framer, sum Checksum) Framer {
+	return func(c Conn, timeout time.Duration) ([]byte, error) {
+		return ReadChecksummed(c, f, sum, timeout)
+	}
+}
+
+// LengthPrefix describes frames that start with a header containing their length.
+type LengthPrefix struct {
+	// Offset is how many bytes of the header come before the length, e.g. a start byte
+	Offset int
+
+	// Size is how many bytes the length is: 1, 2, or 4
+	Size int
+
+	// LittleEndian is true if the length is little endian. Otherwise it's big endian
+	LittleEndian bool
+
+	// Adjust is added to the length to get how many bytes follow the header, e.g. -3 if the length counts a 3 byte header, or 1 if it doesn't count a checksum at the end
+	Adjust int
+
+	// Max is the most bytes allowed after the header, to avoid waiting on a length read from garbage. If <= 0, there's no limit
+	Max int
+}
+
+// Checksum describes a checksum at the end of a frame.
+type Checksum struct {
+	// Skip is how many bytes at the start of the frame the checksum doesn't cover, e.g. a start byte
+	Skip int
+
+	// Trailer is how many bytes come after the checksum, e.g. an end byte
+	Trailer int
+
+	// Sum calculates the checksum of data. It must always return the same number of bytes
+	Sum func(data []byte) []byte
+}
+
+// ChecksumError is returned when a frame's checksum doesn't match its contents.
+type ChecksumError struct {
+	Frame    []byte
+	Expected []byte
+	Got      []byte
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("invalid checksum on frame 0x%x: expected 0x%x, got 0x%x", e.Frame, e.Expected, e.Got)
+}
+
+// Verify checks frame's checksum.
+func (s Checksum) Verify(frame []byte) error {
+	expected := s.Sum(nil)
+	size := len(expected)
+
+	end := len(frame) - s.Trailer - size
+	if end < s.Skip {
+		return fmt.Errorf("frame 0x%x is too short to have a checksum", frame)
+	}
+
+	expected = s.Sum(frame[s.Skip:end])
+	if got := frame[end : end+size]; !bytes.Equal(got, expected) {
+		return &ChecksumError{Frame: frame, Expected: expected, Got: got}
+	}
+
+	return nil
+}
+
+// SumMod256 adds up each byte, keeping the lowest byte of the total.
+func SumMod256(data []byte) []byte {
+	var sum byte
+	for _, b := range data {
+		sum += b
+	}
+
+	return []byte{sum}
+}
+
+// SumXOR XORs each byte together.
+func SumXOR(data []byte) []byte {
+	var sum byte
+	for _, b := range data {
+		sum ^= b
+	}
+
+	return []byte{sum}
+}
+
+// ReadN reads exactly n bytes from c.
+func ReadN(c Conn, n int, timeout time.Duration) ([]byte, error) {
+	c.SetReadDeadline(time.Now().Add(timeout))
+
+	buf := make([]byte, n)
+	read, err := io.ReadFull(c, buf)
+	return buf[:read], err
+}
+
+// ReadUntilAny reads from c until any of delims, returning the data including the delimiter.
+func ReadUntilAny(c Conn, delims []byte, timeout time.Duration) ([]byte, error) {
+	if len(delims) == 1 {
+		return c.ReadUntil(delims[0], timeout)
+	}
+
+	c.SetReadDeadline(time.Now().Add(timeout))
+
+	r := c.ReadWriter().Reader
+
+	var buf []byte
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return buf, err
+		}
+
+		buf = append(buf, b)
+		if bytes.IndexByte(delims, b) >= 0 {
+			return buf, nil
+		}
+	}
+}
+
+// ReadUntilSequence reads from c until seq, returning the data including seq.
+func ReadUntilSequence(c Conn, seq []byte, timeout time.Duration) ([]byte, error) {
+	if len(seq) == 0 {
+		return nil, fmt.Errorf("sequence to read until is empty")
+	}
+
+	c.SetReadDeadline(time.Now().Add(timeout))
+
+	r := c.ReadWriter().Reader
+
+	var buf []byte
+	for {
+		b, err := r.ReadBytes(seq[len(seq)-1])
+		buf = append(buf, b...)
+		if err != nil {
+			return buf, err
+		}
+
+		if bytes.HasSuffix(buf, seq) {
+			return buf, nil
+		}
+	}
+}
+
+// ReadLengthPrefixed reads a frame from c that starts with its length, returning the whole frame including the header.
+func ReadLengthPrefixed(c Conn, prefix LengthPrefix, timeout time.Duration) ([]byte, error) {
+	var order binary.ByteOrder = binary.BigEndian
+	if prefix.LittleEndian {
+		order = binary.LittleEndian
+	}
+
+	if prefix.Size != 1 && prefix.Size != 2 && prefix.Size != 4 {
+		return nil, fmt.Errorf("invalid length size %v: must be 1, 2, or 4", prefix.Size)
+	}
+
+	deadline := time.Now().Add(timeout)
+
+	header, err := ReadN(c, prefix.Offset+prefix.Size, timeout)
+	if err != nil {
+		return header, err
+	}
+
+	var length int
+	field := header[prefix.Offset:]
+
+	switch prefix.Size {
+	case 1:
+		length = int(field[0])
+	case 2:
+		length = int(order.Uint16(field))
+	case 4:
+		length = int(order.Uint32(field))
+	}
+
+	n := length + prefix.Adjust
+	switch {
+	case n < 0:
+		return header, fmt.Errorf("invalid length %v in header 0x%x", length, header)
+	case prefix.Max > 0 && n > prefix.Max:
+		return header, fmt.Errorf("length %v in header 0x%x is more than the max of %v", length, header, prefix.Max)
+	}
+
+	body, err := ReadN(c, n, time.Until(deadline))
+	return append(header, body...), err
+}
+
+// ReadChecksummed reads a frame from c with f, and returns a *ChecksumError along with the frame if its checksum doesn't match.
+func ReadChecksummed(c Conn, f Framer, sum Checksum, timeout time.Duration) ([]byte, error) {
+	frame, err := f(c, timeout)
+	if err != nil {
+		return frame, err
+	}
+
+	return frame, sum.Verify(frame)
+}
+
+// Request writes cmd to c, and then reads frames with reply until match returns true for one, which is returned. Frames that don't match (like notifications the device sends on its own) are passed to notify, which may be nil. If match is nil, the first frame is the reply.
+func Request(c Conn, cmd []byte, reply Framer, match func(frame []byte) bool, notify func(frame []byte), timeout time.Duration) ([]byte, error) {
+	deadline := time.Now().Add(timeout)
+
+	c.SetWriteDeadline(deadline)
+	if _, err := c.Write(cmd); err != nil {
+		// returned as is, so that the map can tell if the connection is broken
+		return nil, err
+	}
+
+	for {
+		frame, err := reply(c, time.Until(deadline))
+		if err != nil {
+			return frame, err
+		}
+
+		if match == nil || match(frame) {
+			return frame, nil
+		}
+
+		c.Log().Debugf("Got unsolicited frame: 0x%x", frame)
+		if notify != nil {
+			notify(frame)
+		}
+	}
+}
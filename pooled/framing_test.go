@@ -0,0 +1,126 @@
+package pooled_test
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/byuoitav/common/pooled"
+	"github.com/byuoitav/common/pooled/pooledtest"
+)
+
+func TestFraming(t *testing.T) {
+	s := pooledtest.NewServer(
+		pooledtest.RespondString("OK\rREADY\n"),
+		pooledtest.RespondInChunks([]byte("PWR=ON\r\nEND"), 2, 5*time.Millisecond),
+		// start byte, 2 byte length, body, checksum
+		pooledtest.Respond([]byte{0x02, 0x00, 0x03, 0x10, 0x20, 0x30, 0x60}),
+		pooledtest.Respond([]byte{0x02, 0x00, 0x01, 0x10, 0x00}),
+	)
+	defer s.Close()
+
+	conn, err := pooled.DialTCP(time.Second)(s.Addr)
+	if err != nil {
+		t.Fatalf("failed to connect: %s", err)
+	}
+	defer conn.Close()
+
+	frame, err := pooled.ReadUntilAny(conn, []byte{'\r', '\n'}, time.Second)
+	if err != nil || string(frame) != "OK\r" {
+		t.Fatalf("expected OK, got %q (err: %v)", frame, err)
+	}
+
+	frame, err = pooled.ReadUntilAny(conn, []byte{'\r', '\n'}, time.Second)
+	if err != nil || string(frame) != "READY\n" {
+		t.Fatalf("expected READY, got %q (err: %v)", frame, err)
+	}
+
+	frame, err = pooled.ReadUntilSequence(conn, []byte("\r\n"), time.Second)
+	if err != nil || string(frame) != "PWR=ON\r\n" {
+		t.Fatalf("expected PWR=ON, got %q (err: %v)", frame, err)
+	}
+
+	frame, err = pooled.ReadN(conn, 3, time.Second)
+	if err != nil || string(frame) != "END" {
+		t.Fatalf("expected END, got %q (err: %v)", frame, err)
+	}
+
+	framer := pooled.Checksummed(
+		pooled.LengthPrefixed(pooled.LengthPrefix{Offset: 1, Size: 2, Adjust: 1, Max: 64}),
+		pooled.Checksum{Skip: 3, Sum: pooled.SumMod256},
+	)
+
+	frame, err = framer(conn, time.Second)
+	if err != nil || !bytes.Equal(frame, []byte{0x02, 0x00, 0x03, 0x10, 0x20, 0x30, 0x60}) {
+		t.Fatalf("expected a valid frame, got 0x%x (err: %v)", frame, err)
+	}
+
+	if _, err = framer(conn, time.Second); err == nil {
+		t.Fatalf("expected a checksum error")
+	} else if _, ok := err.(*pooled.ChecksumError); !ok {
+		t.Fatalf("expected a checksum error, got %s", err)
+	}
+}
+
+func TestRequest(t *testing.T) {
+	s := pooledtest.NewServer(
+		pooledtest.ExpectString("POWER?\r"),
+		pooledtest.RespondString("!INPUT=HDMI1\r"),
+		pooledtest.Delay(10*time.Millisecond),
+		pooledtest.RespondString("!VOLUME=30\rPOWER=ON\r"),
+	)
+	defer s.Close()
+
+	conn, err := pooled.DialTCP(time.Second)(s.Addr)
+	if err != nil {
+		t.Fatalf("failed to connect: %s", err)
+	}
+	defer conn.Close()
+
+	var notifications []string
+	match := func(frame []byte) bool {
+		return bytes.HasPrefix(frame, []byte("POWER="))
+	}
+	notify := func(frame []byte) {
+		notifications = append(notifications, string(frame))
+	}
+
+	resp, err := pooled.Request(conn, []byte("POWER?\r"), pooled.Delimited('\r'), match, notify, time.Second)
+	if err != nil || string(resp) != "POWER=ON\r" {
+		t.Fatalf("expected POWER=ON, got %q (err: %v)", resp, err)
+	}
+
+	if len(notifications) != 2 {
+		t.Fatalf("expected 2 notifications, got %q", notifications)
+	}
+}
+
+func TestRequestClosed(t *testing.T) {
+	s := pooledtest.NewServer()
+	defer s.Close()
+
+	m := pooled.New(pooled.DialTCP(time.Second))
+	defer m.Close(context.Background())
+
+	// the connection breaks before the command is sent
+	err := m.Do(s.Addr, func(conn pooled.Conn) error {
+		conn.Transport().Close()
+
+		_, err := pooled.Request(conn, []byte("POWER?\r"), pooled.Delimited('\r'), nil, nil, time.Second)
+		return err
+	})
+	if _, ok := err.(net.Error); !ok {
+		t.Fatalf("expected a net.Error from sending on a closed connection, got %v", err)
+	}
+
+	// so the map opens a new connection for the next request
+	if err := m.Do(s.Addr, func(pooled.Conn) error { return nil }); err != nil {
+		t.Fatalf("expected a new connection to be opened, got %v", err)
+	}
+
+	if info, ok := m.Conn(s.Addr); !ok || info.Served != 1 {
+		t.Fatalf("expected a new connection, got %+v", info)
+	}
+}
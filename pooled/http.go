@@ -0,0 +1,471 @@
+package pooled
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/byuoitav/common/log"
+	"go.uber.org/zap"
+)
+
+// HTTPWork is work done with a device's HTTP session.
+type HTTPWork func(*HTTPSession) error
+
+// Login logs into a device, e.g. by posting credentials so that the session's cookie jar holds a session cookie.
+// It should send its requests with s.Client, since s.Do tries to log in again when the device rejects a request.
+type Login func(ctx context.Context, s *HTTPSession) error
+
+// HTTPMap keeps an HTTP session for each device, and controls how requests are sent to it, the same way a Map does for TCP devices.
+// By default requests to a device are serialized; use WithConcurrency to allow more at once. WithTTL, WithDelay, WithOpenTimeout (for logging in), WithObserver, WithLogin, WithHTTPKeepAlive, and WithTLSConfig apply to an HTTPMap.
+type HTTPMap struct {
+	options
+
+	sessions map[interface{}]*HTTPSession
+	mu       sync.Mutex
+	closed   bool
+}
+
+// HTTPSession is the HTTP client for a single device. Each session has its own cookie jar and connections.
+type HTTPSession struct {
+	// Key is the key the session was opened for
+	Key interface{}
+
+	// BaseURL is the URL that paths passed to NewRequest are relative to
+	BaseURL *url.URL
+
+	// Client is the client for the device
+	Client *http.Client
+
+	m         *HTTPMap
+	log       *zap.SugaredLogger
+	transport *http.Transport
+
+	// slots has an entry for each request currently being sent to the device
+	slots chan struct{}
+
+	loginMu    sync.Mutex
+	loggedIn   bool
+	generation int
+
+	// these are protected by the map's mutex
+	opened    time.Time
+	connected bool
+	active    int
+	ttl       *time.Timer
+	keep      *time.Timer
+	inflight  sync.WaitGroup
+}
+
+// NewHTTPMap creates an HTTPMap configured by opts. Keys are a device's base URL (e.g. https://10.0.0.1:8080), or just its address, in which case http is used.
+func NewHTTPMap(opts ...Option) *HTTPMap {
+	o := options{
+		ttl:         DefaultTTL,
+		concurrency: 1,
+		observer:    NopObserver{},
+	}
+
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return &HTTPMap{
+		options:  o,
+		sessions: make(map[interface{}]*HTTPSession),
+	}
+}
+
+// Do runs work with the session for key.
+func (m *HTTPMap) Do(key interface{}, work HTTPWork) error {
+	return m.DoContext(context.Background(), key, work)
+}
+
+// DoContext runs work with the session for key, logging in first if needed. If ctx is done before it gets a turn to run, a *TimeoutError is returned. work should pass ctx on to its requests.
+func (m *HTTPMap) DoContext(ctx context.Context, key interface{}, work HTTPWork) error {
+	if err := ctx.Err(); err != nil {
+		return &TimeoutError{Key: key, Queued: true, Err: err}
+	}
+
+	s, err := m.get(key)
+	if err != nil {
+		return err
+	}
+	defer m.done(s)
+
+	return s.run(ctx, work)
+}
+
+// get returns the session for key, creating one if there isn't one yet. The caller must call done once it's finished with the session.
+func (m *HTTPMap) get(key interface{}) (*HTTPSession, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return nil, ErrClosed
+	}
+
+	s, ok := m.sessions[key]
+	if !ok {
+		var err error
+		s, err = m.newSession(key)
+		if err != nil {
+			return nil, err
+		}
+
+		m.sessions[key] = s
+	}
+
+	s.active++
+	s.inflight.Add(1)
+	s.ttl.Stop()
+	if s.keep != nil {
+		s.keep.Stop()
+	}
+
+	return s, nil
+}
+
+// done restarts the session's ttl and keep alive once nothing is using it
+func (m *HTTPMap) done(s *HTTPSession) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s.active--
+	s.inflight.Done()
+
+	if s.active == 0 {
+		s.ttl.Reset(m.ttl)
+		if s.keep != nil {
+			s.keep.Reset(m.keepAliveInterval)
+		}
+	}
+}
+
+func (m *HTTPMap) newSession(key interface{}) (*HTTPSession, error) {
+	raw := fmt.Sprintf("%v", key)
+	if !strings.Contains(raw, "://") {
+		raw = "http://" + raw
+	}
+
+	base, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid device address %v: %s", key, err)
+	}
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create cookie jar: %s", err)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxConnsPerHost = m.concurrency
+	transport.MaxIdleConnsPerHost = m.concurrency
+	if m.tlsConfig != nil {
+		transport.TLSClientConfig = m.tlsConfig
+	}
+
+	s := &HTTPSession{
+		Key:     key,
+		BaseURL: base,
+		Client: &http.Client{
+			Jar:       jar,
+			Transport: transport,
+		},
+		m:         m,
+		log:       log.L.Named(raw),
+		transport: transport,
+		slots:     make(chan struct{}, m.concurrency),
+		opened:    time.Now(),
+	}
+
+	s.ttl = time.AfterFunc(m.ttl, func() {
+		m.expire(s)
+	})
+
+	if m.httpKeepAlive != nil && m.keepAliveInterval > 0 {
+		s.keep = time.AfterFunc(m.keepAliveInterval, func() {
+			m.keepAlive(s)
+		})
+	}
+
+	return s, nil
+}
+
+// expire closes s if it hasn't been used since its ttl was reset
+func (m *HTTPMap) expire(s *HTTPSession) {
+	m.mu.Lock()
+	if s.active > 0 || m.sessions[s.Key] != s {
+		m.mu.Unlock()
+		return
+	}
+
+	delete(m.sessions, s.Key)
+	m.mu.Unlock()
+
+	s.close()
+}
+
+func (m *HTTPMap) keepAlive(s *HTTPSession) {
+	m.mu.Lock()
+	if m.sessions[s.Key] != s {
+		m.mu.Unlock()
+		return
+	}
+
+	// keep alives don't count as activity for the ttl
+	s.inflight.Add(1)
+	m.mu.Unlock()
+
+	defer s.inflight.Done()
+
+	s.log.Debugf("Sending keep alive")
+	if err := s.run(context.Background(), m.httpKeepAlive); err != nil {
+		s.log.Warnf("keep alive failed: %s", err)
+		m.observer.Error(s.Key, err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// if the session is being used, done restarts the keep alive once it isn't
+	if m.sessions[s.Key] == s && s.active == 0 {
+		s.keep.Reset(m.keepAliveInterval)
+	}
+}
+
+// Keys returns the key of each open session.
+func (m *HTTPMap) Keys() []interface{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keys := make([]interface{}, 0, len(m.sessions))
+	for key := range m.sessions {
+		keys = append(keys, key)
+	}
+
+	return keys
+}
+
+// Close stops the map from accepting new work and closes every session once the work using it has finished.
+// If ctx is done before that finishes, the remaining sessions are closed immediately and ctx's error is returned.
+func (m *HTTPMap) Close(ctx context.Context) error {
+	m.mu.Lock()
+	m.closed = true
+
+	sessions := make([]*HTTPSession, 0, len(m.sessions))
+	for key, s := range m.sessions {
+		sessions = append(sessions, s)
+		delete(m.sessions, key)
+	}
+	m.mu.Unlock()
+
+	var err error
+	for _, s := range sessions {
+		finished := make(chan struct{})
+		go func(s *HTTPSession) {
+			s.inflight.Wait()
+			close(finished)
+		}(s)
+
+		if err == nil {
+			select {
+			case <-finished:
+			case <-ctx.Done():
+				err = ctx.Err()
+			}
+		}
+
+		s.close()
+	}
+
+	return err
+}
+
+// run waits for a turn to send requests to the device, logs in if needed, and then runs work
+func (s *HTTPSession) run(ctx context.Context, work HTTPWork) error {
+	queued := time.Now()
+
+	select {
+	case s.slots <- struct{}{}:
+	case <-ctx.Done():
+		terr := &TimeoutError{Key: s.Key, Queued: true, Err: ctx.Err()}
+		s.m.observer.Error(s.Key, terr)
+		return terr
+	}
+
+	defer func() {
+		// delay before the next request is sent
+		time.Sleep(s.m.delay)
+		<-s.slots
+	}()
+
+	if err := s.ensureLogin(ctx); err != nil {
+		return err
+	}
+
+	start := time.Now()
+	s.m.observer.WorkStarted(s.Key, start.Sub(queued))
+
+	err := work(s)
+
+	s.m.observer.WorkFinished(s.Key, time.Since(start), err)
+	return err
+}
+
+// ensureLogin logs into the device the first time it's used
+func (s *HTTPSession) ensureLogin(ctx context.Context) error {
+	s.loginMu.Lock()
+	defer s.loginMu.Unlock()
+
+	if s.loggedIn {
+		return nil
+	}
+
+	if s.m.login != nil {
+		s.log.Infof("Logging in")
+
+		if err := s.login(ctx); err != nil {
+			err = fmt.Errorf("failed to log into %v: %s", s.Key, err)
+			s.m.observer.ConnOpenFailed(s.Key, err)
+			return err
+		}
+	}
+
+	s.loggedIn = true
+	s.m.observer.ConnOpened(s.Key)
+
+	s.m.mu.Lock()
+	s.connected = true
+	s.m.mu.Unlock()
+
+	return nil
+}
+
+// login calls the map's login function, with the open timeout. The caller must hold loginMu
+func (s *HTTPSession) login(ctx context.Context) error {
+	if s.m.openTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.m.openTimeout)
+		defer cancel()
+	}
+
+	if err := s.m.login(ctx, s); err != nil {
+		return err
+	}
+
+	s.generation++
+	return nil
+}
+
+// relogin logs in again after a request sent during generation was rejected, unless someone else already has
+func (s *HTTPSession) relogin(ctx context.Context, generation int) error {
+	s.loginMu.Lock()
+	defer s.loginMu.Unlock()
+
+	if s.generation != generation {
+		return nil
+	}
+
+	s.log.Infof("Session expired, logging in again")
+	return s.login(ctx)
+}
+
+func (s *HTTPSession) close() {
+	s.ttl.Stop()
+	if s.keep != nil {
+		s.keep.Stop()
+	}
+
+	s.transport.CloseIdleConnections()
+
+	s.log.Infof("Closing session")
+
+	// only sessions that were reported as opened are reported as closed
+	s.m.mu.Lock()
+	connected := s.connected
+	s.m.mu.Unlock()
+
+	if connected {
+		s.m.observer.ConnClosed(s.Key, time.Since(s.opened))
+	}
+}
+
+// Log returns the session's logger.
+func (s *HTTPSession) Log() *zap.SugaredLogger {
+	return s.log
+}
+
+// NewRequest creates a request for path, which is relative to the session's base URL.
+func (s *HTTPSession) NewRequest(ctx context.Context, method, path string, body io.Reader) (*http.Request, error) {
+	ref, err := url.Parse(path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid path %q: %s", path, err)
+	}
+
+	return http.NewRequestWithContext(ctx, method, s.BaseURL.ResolveReference(ref).String(), body)
+}
+
+// ErrUnauthorized is returned by HTTPSession.Do when the device still rejects a request after logging in again.
+var ErrUnauthorized = errors.New("device rejected the session")
+
+// Do sends req with the session's client. If the device responds with 401 or 403 and a login function is set, it logs in again and resends req once.
+// A request with a body can only be resent if its GetBody is set, which http.NewRequest does for common body types.
+func (s *HTTPSession) Do(req *http.Request) (*http.Response, error) {
+	s.loginMu.Lock()
+	generation := s.generation
+	s.loginMu.Unlock()
+
+	// the client adds the jar's cookies to req's header, so keep a copy without them for the retry
+	header := req.Header.Clone()
+
+	resp, err := s.Client.Do(req)
+	if err != nil || s.m.login == nil || !rejected(resp) {
+		return resp, err
+	}
+
+	if req.Body != nil && req.GetBody == nil {
+		return resp, nil
+	}
+
+	resp.Body.Close()
+
+	if err := s.relogin(req.Context(), generation); err != nil {
+		err = fmt.Errorf("failed to log into %v again: %s", s.Key, err)
+		s.m.observer.Error(s.Key, err)
+		return nil, err
+	}
+
+	retry := req.Clone(req.Context())
+	retry.Header = header
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, fmt.Errorf("unable to resend request: %s", err)
+		}
+
+		retry.Body = body
+	}
+
+	resp, err = s.Client.Do(retry)
+	if err != nil {
+		return resp, err
+	}
+
+	if rejected(resp) {
+		resp.Body.Close()
+		return nil, ErrUnauthorized
+	}
+
+	return resp, nil
+}
+
+func rejected(resp *http.Response) bool {
+	return resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden
+}
@@ -0,0 +1,185 @@
+package pooled_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/byuoitav/common/pooled"
+)
+
+func TestHTTPMapLogin(t *testing.T) {
+	var mu sync.Mutex
+	session := "first"
+	logins := 0
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if r.URL.Path == "/login" {
+			logins++
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: session, Path: "/"})
+			return
+		}
+
+		if c, err := r.Cookie("session"); err != nil || c.Value != session {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		w.Write([]byte("on"))
+	}))
+	defer srv.Close()
+
+	login := func(ctx context.Context, s *pooled.HTTPSession) error {
+		req, err := s.NewRequest(ctx, http.MethodPost, "/login", nil)
+		if err != nil {
+			return err
+		}
+
+		resp, err := s.Client.Do(req)
+		if err != nil {
+			return err
+		}
+
+		return resp.Body.Close()
+	}
+
+	m := pooled.NewHTTPMap(pooled.WithLogin(login), pooled.WithConcurrency(2))
+	defer m.Close(context.Background())
+
+	getPower := func() (string, error) {
+		var power string
+		err := m.Do(srv.URL, func(s *pooled.HTTPSession) error {
+			req, err := s.NewRequest(context.Background(), http.MethodGet, "/power", nil)
+			if err != nil {
+				return err
+			}
+
+			resp, err := s.Do(req)
+			if err != nil {
+				return err
+			}
+			defer resp.Body.Close()
+
+			body, err := ioutil.ReadAll(resp.Body)
+			power = string(body)
+			return err
+		})
+
+		return power, err
+	}
+
+	if power, err := getPower(); err != nil || power != "on" {
+		t.Fatalf("expected power to be on, got %q (err: %v)", power, err)
+	}
+
+	// the device forgets about the session, so we should log in again
+	mu.Lock()
+	session = "second"
+	mu.Unlock()
+
+	if power, err := getPower(); err != nil || power != "on" {
+		t.Fatalf("expected power to be on after logging in again, got %q (err: %v)", power, err)
+	}
+
+	if logins != 2 {
+		t.Fatalf("expected to log in twice, logged in %v times", logins)
+	}
+}
+
+// connObserver counts the sessions that are opened and closed
+type connObserver struct {
+	pooled.NopObserver
+
+	mu     sync.Mutex
+	opened int
+	closed int
+}
+
+func (o *connObserver) ConnOpened(key interface{}) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.opened++
+}
+
+func (o *connObserver) ConnClosed(key interface{}, age time.Duration) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.closed++
+}
+
+func TestHTTPMapFailedLogin(t *testing.T) {
+	login := func(ctx context.Context, s *pooled.HTTPSession) error {
+		return errors.New("bad password")
+	}
+
+	o := &connObserver{}
+	m := pooled.NewHTTPMap(pooled.WithLogin(login), pooled.WithObserver(o))
+
+	if err := m.Do("127.0.0.1:1", func(*pooled.HTTPSession) error { return nil }); err == nil {
+		t.Fatalf("expected the login to fail")
+	}
+
+	if err := m.Close(context.Background()); err != nil {
+		t.Fatalf("failed to close map: %s", err)
+	}
+
+	// a session that never logged in was never opened, so it isn't closed either
+	if o.opened != 0 || o.closed != 0 {
+		t.Fatalf("expected no sessions to be opened or closed, got %v opened and %v closed", o.opened, o.closed)
+	}
+}
+
+func TestHTTPKeepAlive(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	var mu sync.Mutex
+	sent := 0
+
+	keepAlive := func(*pooled.HTTPSession) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		sent++
+		return nil
+	}
+
+	count := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return sent
+	}
+
+	m := pooled.NewHTTPMap(pooled.WithHTTPKeepAlive(50*time.Millisecond, keepAlive))
+	defer m.Close(context.Background())
+
+	// keep alives aren't sent while the session is being used
+	for i := 0; i < 10; i++ {
+		if err := m.Do(srv.URL, func(*pooled.HTTPSession) error { return nil }); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if n := count(); n != 0 {
+		t.Fatalf("expected no keep alives while the session was busy, got %v", n)
+	}
+
+	time.Sleep(80 * time.Millisecond)
+
+	if n := count(); n == 0 {
+		t.Fatalf("expected a keep alive once the session was idle")
+	}
+}
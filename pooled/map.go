@@ -1,7 +1,10 @@
 package pooled
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net"
 	"sync"
 	"time"
@@ -9,6 +12,12 @@ import (
 	"github.com/byuoitav/common/log"
 )
 
+// ErrClosed is returned when work is sent to a Map that has been closed.
+var ErrClosed = errors.New("pooled map is closed")
+
+// ErrEvicted is returned for work that was still queued when its connection was evicted.
+var ErrEvicted = errors.New("connection was evicted")
+
 // NewConnection .
 type NewConnection func(key interface{}) (Conn, error)
 
@@ -17,123 +26,442 @@ type Work func(Conn) error
 
 // Map .
 type Map struct {
+	options
+
 	newConn NewConnection
-	ttl     time.Duration
-	delay   time.Duration
 
-	m  map[interface{}]chan request
-	mu sync.Mutex
+	// slots has an entry for each open connection, if the number of connections is limited
+	slots chan struct{}
+
+	m        map[interface{}]*worker
+	breakers map[interface{}]*breaker
+	flights  map[flightKey]*flight
+	mu       sync.Mutex
+	closed   bool
 }
 
 type request struct {
-	work Work
-	resp chan error
+	ctx      context.Context
+	work     Work
+	resp     chan error
+	queued   time.Time
+	priority Priority
+
+	// started is called right before the work runs, if it's set
+	started func()
 }
 
 // NewMap .
 func NewMap(ttl, delay time.Duration, newConn NewConnection) *Map {
-	return &Map{
-		m:       make(map[interface{}]chan request),
-		newConn: newConn,
-		ttl:     ttl,
+	return New(newConn, WithTTL(ttl), WithDelay(delay))
+}
+
+// New creates a Map that opens connections with newConn, configured by opts.
+func New(newConn NewConnection, opts ...Option) *Map {
+	o := options{
+		ttl:       DefaultTTL,
+		queueSize: DefaultQueueSize,
+		observer:  NopObserver{},
 	}
+
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	m := &Map{
+		options:  o,
+		m:        make(map[interface{}]*worker),
+		breakers: make(map[interface{}]*breaker),
+		flights:  make(map[flightKey]*flight),
+		newConn:  newConn,
+	}
+
+	if o.maxConns > 0 {
+		m.slots = make(chan struct{}, o.maxConns)
+	}
+
+	return m
 }
 
 // Do .
 func (m *Map) Do(key interface{}, work Work) error {
-	var reqs chan request
-	var ok bool
+	return m.DoContext(context.Background(), key, work)
+}
 
+// DoContext runs work on the connection for key, like Do, but gives up once ctx is done.
+// Work that is still queued is skipped, and the connection's read/write deadlines are never later than ctx's deadline. If ctx is done first, a *TimeoutError is returned.
+// Work is queued with the priority from ctx (see ContextWithPriority); background work only runs when no user work is queued for key.
+func (m *Map) DoContext(ctx context.Context, key interface{}, work Work) error {
+	req := request{
+		ctx:      ctx,
+		work:     work,
+		resp:     make(chan error, 1),
+		queued:   time.Now(),
+		priority: PriorityFromContext(ctx),
+	}
+
+	if err := m.send(ctx, key, req); err != nil {
+		return err
+	}
+
+	select {
+	case err := <-req.resp:
+		if err != nil {
+			if cerr := contextErr(ctx); cerr != nil {
+				// the work failed because it was cut off
+				return &TimeoutError{Key: key, Err: cerr}
+			}
+		}
+
+		return err
+	case <-ctx.Done():
+		return &TimeoutError{Key: key, Err: ctx.Err()}
+	}
+}
+
+// send queues req on the worker for key, by its priority
+func (m *Map) send(ctx context.Context, key interface{}, req request) error {
+	if err := ctx.Err(); err != nil {
+		return &TimeoutError{Key: key, Queued: true, Err: err}
+	}
+
+	w, err := m.get(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	queue := w.reqs
+	if req.priority == PriorityBackground {
+		queue = w.background
+	}
+
+	select {
+	case queue <- req:
+		w.pending.Done()
+		return nil
+	case <-ctx.Done():
+		w.pending.Done()
+		return &TimeoutError{Key: key, Queued: true, Err: ctx.Err()}
+	}
+}
+
+// get returns the worker for key, opening a new connection if there isn't one. The caller must call pending.Done() on the worker once it has sent its request.
+func (m *Map) get(ctx context.Context, key interface{}) (*worker, error) {
 	l := log.L.Named(fmt.Sprintf("%s", key))
 
+	w, b, err := m.lookup(key)
+	if w != nil || err != nil {
+		return w, err
+	}
+
+	if err := b.check(key, m.breaker); err != nil {
+		m.observer.Error(key, err)
+		return nil, err
+	}
+
+	// only one request opens a connection for key at a time
+	select {
+	case b.sem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, &TimeoutError{Key: key, Queued: true, Err: ctx.Err()}
+	}
+	defer func() { <-b.sem }()
+
+	// the connection may have been opened while we were waiting
+	if w, _, err := m.lookup(key); w != nil || err != nil {
+		return w, err
+	}
+
+	if err := b.check(key, m.breaker); err != nil {
+		m.observer.Error(key, err)
+		return nil, err
+	}
+
+	// wait out the backoff from the last failure
+	if wait := b.start(m.breaker); wait > 0 {
+		l.Infof("Waiting %v before opening a new connection", wait)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			b.abort()
+			return nil, &TimeoutError{Key: key, Queued: true, Err: ctx.Err()}
+		}
+	}
+
+	// wait for another connection to close if we're at the limit
+	if m.slots != nil {
+		select {
+		case m.slots <- struct{}{}:
+		case <-ctx.Done():
+			b.abort()
+			return nil, &TimeoutError{Key: key, Queued: true, Err: ctx.Err()}
+		}
+	}
+
+	// open a new connection
+	l.Infof("Opening new connection")
+	conn, err := m.open(ctx, key)
+	if err != nil {
+		m.release()
+
+		if _, ok := err.(*TimeoutError); ok {
+			b.abort()
+			return nil, err
+		}
+
+		err = fmt.Errorf("failed to open new connection for %s: %s", key, err)
+		b.failure(err, m.backoff, m.breaker)
+		m.observer.ConnOpenFailed(key, err)
+		return nil, err
+	}
+
+	b.success()
+
+	conn.Log().Infof("Successfully opened new connection")
+	m.observer.ConnOpened(key)
+
 	m.mu.Lock()
-	if reqs, ok = m.m[key]; !ok {
-		// open a new connection
-		l.Infof("Opening new connection")
+	defer m.mu.Unlock()
+
+	if m.closed {
+		conn.Close()
+		m.release()
+		m.observer.ConnClosed(key, 0)
+		return nil, ErrClosed
+	}
+
+	w = newWorker(m, key, conn)
+	w.pending.Add(1)
+	m.m[key] = w
+
+	go w.run()
+	return w, nil
+}
+
+// open calls newConn, giving up if it takes longer than the open timeout or ctx is done first
+func (m *Map) open(ctx context.Context, key interface{}) (Conn, error) {
+	type result struct {
+		conn Conn
+		err  error
+	}
+
+	opened := make(chan result, 1)
+	go func() {
 		conn, err := m.newConn(key)
-		if err != nil {
-			m.mu.Unlock()
-			return fmt.Errorf("failed to open new connection for %s: %s", key, err)
+		if err == nil && conn == nil {
+			err = errors.New("got nil connection from new connection function")
 		}
 
-		if conn == nil {
-			m.mu.Unlock()
-			return fmt.Errorf("got nil connection from new connection function")
-		}
+		opened <- result{conn: conn, err: err}
+	}()
 
-		reqs = make(chan request, 10)
-		m.m[key] = reqs
-		m.mu.Unlock()
+	var timeout <-chan time.Time
+	if m.openTimeout > 0 {
+		timer := time.NewTimer(m.openTimeout)
+		defer timer.Stop()
 
-		conn.Log().Infof("Successfully opened new connection")
+		timeout = timer.C
+	}
 
+	// close the connection if it opens after we've given up on it
+	abandon := func() {
 		go func() {
-			defer func() {
-				m.mu.Lock()
-				delete(m.m, key)
-				m.mu.Unlock()
-
-				conn.Log().Infof("Closing connection")
-				close(reqs)
-
-				// finish up remaining requests
-				for req := range reqs {
-					req.resp <- req.work(conn)
-					time.Sleep(m.delay)
-				}
-
-				conn.netconn().Close()
-			}()
-
-			timer := time.NewTimer(m.ttl)
-
-			for {
-				// delay before the next command is sent
-				time.Sleep(m.delay)
-
-				// reset the buffer by reading everything currently in it
-				bytes, err := conn.EmptyReadBuffer(m.ttl)
-				if err != nil {
-					conn.Log().Warnf("failed to empty buffer: %s", err)
-					return
-				}
-				if len(bytes) > 0 {
-					conn.Log().Debugf("Read %v leftover bytes: 0x%x", len(bytes), bytes)
-				}
-
-				// reset the deadlines
-				conn.netconn().SetDeadline(time.Time{})
-
-				select {
-				case req := <-reqs:
-					err := req.work(conn)
-					req.resp <- err
-					if err, ok := err.(net.Error); ok && (!err.Temporary() || err.Timeout()) {
-						// if it was a timeout error, close the connection
-						conn.Log().Warnf("closing connection due to non-temporary or timeout error: %s", err.Error())
-						return
-					}
-
-					// reset the timer
-					if !timer.Stop() {
-						<-timer.C
-					}
-					timer.Reset(m.ttl)
-				case <-timer.C:
-					return
-				}
+			if r := <-opened; r.conn != nil {
+				r.conn.Close()
 			}
 		}()
-	} else {
-		l.Infof("Reusing already open connection")
-		m.mu.Unlock()
 	}
 
-	req := request{
-		work: work,
-		resp: make(chan error),
+	select {
+	case r := <-opened:
+		return r.conn, r.err
+	case <-timeout:
+		abandon()
+		return nil, fmt.Errorf("timed out after %v", m.openTimeout)
+	case <-ctx.Done():
+		abandon()
+		return nil, &TimeoutError{Key: key, Queued: true, Err: ctx.Err()}
+	}
+}
+
+// release frees up the slot held by a connection, if the number of connections is limited
+func (m *Map) release() {
+	if m.slots != nil {
+		<-m.slots
+	}
+}
+
+// lookup returns the open worker for key, or the breaker to use to open a new connection if there isn't one
+func (m *Map) lookup(key interface{}) (*worker, *breaker, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return nil, nil, ErrClosed
+	}
+
+	if w, ok := m.m[key]; ok {
+		w.conn.Log().Infof("Reusing already open connection")
+		w.pending.Add(1)
+		return w, nil, nil
+	}
+
+	b, ok := m.breakers[key]
+	if !ok {
+		b = newBreaker()
+		m.breakers[key] = b
+	}
+
+	return nil, b, nil
+}
+
+// CircuitOpen returns true if key's circuit breaker is open, and requests for it are failing without trying to connect. It returns false once the cooldown has passed and the next request is allowed to try again.
+func (m *Map) CircuitOpen(key interface{}) bool {
+	m.mu.Lock()
+	b, ok := m.breakers[key]
+	m.mu.Unlock()
+
+	return ok && b.open(m.breaker)
+}
+
+// remove takes w out of the map, so that no more work is sent to it. Its key's breaker is dropped too if it's idle, so that keys that are no longer used don't pile up
+func (m *Map) remove(w *worker) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if cur, ok := m.m[w.key]; ok && cur == w {
+		delete(m.m, w.key)
+	}
+
+	if b, ok := m.breakers[w.key]; ok && b.idle() {
+		delete(m.breakers, w.key)
+	}
+}
+
+// Keys returns the key of each open connection.
+func (m *Map) Keys() []interface{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keys := make([]interface{}, 0, len(m.m))
+	for key := range m.m {
+		keys = append(keys, key)
+	}
+
+	return keys
+}
+
+// Conns returns information about each open connection.
+func (m *Map) Conns() []ConnInfo {
+	m.mu.Lock()
+	workers := make([]*worker, 0, len(m.m))
+	for _, w := range m.m {
+		workers = append(workers, w)
+	}
+	m.mu.Unlock()
+
+	infos := make([]ConnInfo, 0, len(workers))
+	for _, w := range workers {
+		infos = append(infos, w.info())
+	}
+
+	return infos
+}
+
+// Conn returns information about the open connection for key. The bool is false if there isn't one.
+func (m *Map) Conn(key interface{}) (ConnInfo, bool) {
+	m.mu.Lock()
+	w, ok := m.m[key]
+	m.mu.Unlock()
+
+	if !ok {
+		return ConnInfo{}, false
+	}
+
+	return w.info(), true
+}
+
+// Evict closes the connection for key right away, failing any work that is still queued for it with ErrEvicted. The next request for key opens a new connection.
+// It returns false if there wasn't an open connection for key.
+func (m *Map) Evict(key interface{}) bool {
+	m.mu.Lock()
+	w, ok := m.m[key]
+	if ok {
+		delete(m.m, key)
+	}
+	m.mu.Unlock()
+
+	if !ok {
+		return false
+	}
+
+	w.conn.Log().Infof("Evicting connection")
+	w.stop(ErrEvicted)
+	return true
+}
+
+// Close stops the map from accepting new work, finishes any work that is already queued, and closes every connection.
+// If ctx is done before that finishes, the remaining connections are closed immediately and ctx's error is returned.
+func (m *Map) Close(ctx context.Context) error {
+	m.mu.Lock()
+	m.closed = true
+
+	workers := make([]*worker, 0, len(m.m))
+	for key, w := range m.m {
+		workers = append(workers, w)
+		delete(m.m, key)
+	}
+	m.mu.Unlock()
+
+	for _, w := range workers {
+		w.stop(nil)
+	}
+
+	for _, w := range workers {
+		select {
+		case <-w.done:
+		case <-ctx.Done():
+			for _, w := range workers {
+				w.stop(ErrClosed)
+			}
+
+			return ctx.Err()
+		}
+	}
+
+	return nil
+}
+
+// contextErr returns ctx's error, or context.DeadlineExceeded if its deadline has passed but ctx hasn't noticed yet
+func contextErr(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok && !time.Now().Before(deadline) {
+		return context.DeadlineExceeded
+	}
+
+	return nil
+}
+
+// shouldClose returns true if err means the connection can't be used anymore
+func shouldClose(err error) bool {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		// the device hung up
+		return true
+	}
+
+	switch err := err.(type) {
+	case *TimeoutError:
+		// the request was abandoned, but the connection is still fine
+		return false
+	case net.Error:
+		return !err.Temporary() || err.Timeout()
 	}
 
-	reqs <- req
-	return <-req.resp
+	return false
 }
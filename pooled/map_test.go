@@ -0,0 +1,766 @@
+package pooled_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/byuoitav/common/pooled"
+	"github.com/byuoitav/common/pooled/pooledtest"
+)
+
+// request returns work that sends req and reads a response up to a newline into resp
+func request(req string, resp *string, timeout time.Duration) pooled.Work {
+	return func(conn pooled.Conn) error {
+		if _, err := conn.Write([]byte(req)); err != nil {
+			return err
+		}
+
+		b, err := conn.ReadUntil('\n', timeout)
+		*resp = string(b)
+		return err
+	}
+}
+
+// waitForClose waits for the connection for key to be closed
+func waitForClose(t *testing.T, m *pooled.Map, key interface{}) {
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, ok := m.Conn(key); !ok {
+			return
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for the connection to %v to close", key)
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestDoContextDeadline(t *testing.T) {
+	// the device never answers the first request
+	s := pooledtest.NewServer(
+		pooledtest.ExpectString("PWR?\n"),
+		pooledtest.Delay(200*time.Millisecond),
+		pooledtest.Disconnect(),
+		pooledtest.ExpectString("PWR?\n"),
+		pooledtest.RespondString("ON\n"),
+	)
+	defer s.Close()
+
+	m := pooled.New(pooled.DialTCP(time.Second))
+	defer m.Close(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	// the read timeout is cut short by ctx's deadline
+	var resp string
+	start := time.Now()
+
+	err := m.DoContext(ctx, s.Addr, request("PWR?\n", &resp, 5*time.Second))
+	if terr, ok := err.(*pooled.TimeoutError); !ok || terr.Queued {
+		t.Fatalf("expected a timeout while the work was running, got %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected the read to stop at the deadline, but it took %v", elapsed)
+	}
+
+	// work that is already past its deadline is never queued
+	if err := m.DoContext(ctx, s.Addr, request("PWR?\n", &resp, time.Second)); err == nil {
+		t.Fatalf("expected work with an expired context to fail")
+	} else if terr, ok := err.(*pooled.TimeoutError); !ok || !terr.Queued {
+		t.Fatalf("expected a queued timeout, got %v", err)
+	}
+
+	// timing out closed the connection, so the next request opens a new one
+	waitForClose(t, m, s.Addr)
+
+	if err := m.Do(s.Addr, request("PWR?\n", &resp, time.Second)); err != nil || resp != "ON\n" {
+		t.Fatalf("expected ON, got %q (err: %v)", resp, err)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatalf("unexpected server errors: %s", err)
+	}
+}
+
+func TestDoContextSkipsQueuedWork(t *testing.T) {
+	s := pooledtest.NewServer()
+	defer s.Close()
+
+	m := pooled.New(pooled.DialTCP(time.Second))
+	defer m.Close(context.Background())
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	done := make(chan error, 1)
+
+	go func() {
+		done <- m.Do(s.Addr, func(pooled.Conn) error {
+			close(started)
+			<-release
+			return nil
+		})
+	}()
+
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	ran := make(chan struct{}, 1)
+	err := m.DoContext(ctx, s.Addr, func(pooled.Conn) error {
+		ran <- struct{}{}
+		return nil
+	})
+	if _, ok := err.(*pooled.TimeoutError); !ok {
+		t.Fatalf("expected a timeout while the work was queued, got %v", err)
+	}
+
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatalf("unexpected error from the first request: %s", err)
+	}
+
+	// the worker moves on to the abandoned work, and skips it
+	if err := m.Do(s.Addr, func(pooled.Conn) error { return nil }); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	select {
+	case <-ran:
+		t.Fatalf("expected abandoned work to be skipped")
+	default:
+	}
+}
+
+func TestMapClosesOnEOF(t *testing.T) {
+	s := pooledtest.NewServer(
+		pooledtest.ExpectString("PWR?\n"),
+		pooledtest.RespondString("ON\n"),
+		pooledtest.Disconnect(),
+		pooledtest.ExpectString("PWR?\n"),
+		pooledtest.RespondString("STANDBY\n"),
+	)
+	defer s.Close()
+
+	m := pooled.New(pooled.DialTCP(time.Second))
+	defer m.Close(context.Background())
+
+	var resp string
+	if err := m.Do(s.Addr, request("PWR?\n", &resp, time.Second)); err != nil || resp != "ON\n" {
+		t.Fatalf("expected ON, got %q (err: %v)", resp, err)
+	}
+
+	// the device hung up, so reading hits EOF
+	err := m.Do(s.Addr, func(conn pooled.Conn) error {
+		_, err := conn.ReadUntil('\n', time.Second)
+		return err
+	})
+	if err == nil {
+		t.Fatalf("expected reading from a closed connection to fail")
+	}
+
+	// which closes the connection, so the next request opens a new one
+	if err := m.Do(s.Addr, request("PWR?\n", &resp, time.Second)); err != nil || resp != "STANDBY\n" {
+		t.Fatalf("expected STANDBY, got %q (err: %v)", resp, err)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatalf("unexpected server errors: %s", err)
+	}
+}
+
+func TestMapClose(t *testing.T) {
+	s := pooledtest.NewServer(
+		pooledtest.ExpectString("PWR?\n"),
+		pooledtest.Delay(50*time.Millisecond),
+		pooledtest.RespondString("ON\n"),
+		pooledtest.ExpectString("INPUT?\n"),
+		pooledtest.RespondString("HDMI1\n"),
+	)
+	defer s.Close()
+
+	m := pooled.New(pooled.DialTCP(time.Second))
+
+	var power, input string
+	errs := make(chan error, 2)
+
+	started := make(chan struct{})
+	go func() {
+		errs <- m.Do(s.Addr, func(conn pooled.Conn) error {
+			close(started)
+			return request("PWR?\n", &power, time.Second)(conn)
+		})
+	}()
+
+	// the second request is queued behind the first one
+	<-started
+	go func() { errs <- m.Do(s.Addr, request("INPUT?\n", &input, time.Second)) }()
+	time.Sleep(10 * time.Millisecond)
+
+	// queued work is finished before the connection is closed
+	if err := m.Close(context.Background()); err != nil {
+		t.Fatalf("failed to close map: %s", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("unexpected error from queued work: %s", err)
+		}
+	}
+
+	if power != "ON\n" || input != "HDMI1\n" {
+		t.Fatalf("expected queued work to finish, got %q and %q", power, input)
+	}
+
+	if err := m.Do(s.Addr, request("PWR?\n", &power, time.Second)); err != pooled.ErrClosed {
+		t.Fatalf("expected ErrClosed after closing, got %v", err)
+	}
+
+	if keys := m.Keys(); len(keys) != 0 {
+		t.Fatalf("expected every connection to be closed, got %v", keys)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatalf("unexpected server errors: %s", err)
+	}
+}
+
+func TestMapCloseTimeout(t *testing.T) {
+	s := pooledtest.NewServer()
+	defer s.Close()
+
+	m := pooled.New(pooled.DialTCP(time.Second))
+
+	started := make(chan struct{})
+	done := make(chan error, 1)
+
+	// this work never finishes on its own
+	go func() {
+		done <- m.Do(s.Addr, func(conn pooled.Conn) error {
+			close(started)
+
+			_, err := conn.ReadUntil('\n', 5*time.Second)
+			return err
+		})
+	}()
+
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if err := m.Close(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected close to time out, got %v", err)
+	}
+
+	// so the connection is closed out from under it
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatalf("expected the running work to fail once its connection was closed")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected the running work to be cut off")
+	}
+}
+
+func TestMapEvict(t *testing.T) {
+	s := pooledtest.NewServer()
+	defer s.Close()
+
+	m := pooled.New(pooled.DialTCP(time.Second))
+	defer m.Close(context.Background())
+
+	if m.Evict(s.Addr) {
+		t.Fatalf("expected nothing to be evicted before a connection is opened")
+	}
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	running := make(chan error, 1)
+	queued := make(chan error, 1)
+
+	go func() {
+		running <- m.Do(s.Addr, func(pooled.Conn) error {
+			close(started)
+			<-release
+			return nil
+		})
+	}()
+
+	<-started
+
+	go func() {
+		queued <- m.Do(s.Addr, func(pooled.Conn) error { return nil })
+	}()
+
+	for deadline := time.Now().Add(time.Second); ; {
+		if info, _ := m.Conn(s.Addr); info.QueueDepth == 1 {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for work to be queued")
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if keys := m.Keys(); len(keys) != 1 || keys[0] != s.Addr {
+		t.Fatalf("expected a connection to %v, got %v", s.Addr, keys)
+	}
+
+	if conns := m.Conns(); len(conns) != 1 || conns[0].Key != s.Addr {
+		t.Fatalf("expected a connection to %v, got %+v", s.Addr, conns)
+	}
+
+	if !m.Evict(s.Addr) {
+		t.Fatalf("expected the connection to be evicted")
+	}
+
+	if keys := m.Keys(); len(keys) != 0 {
+		t.Fatalf("expected no open connections after evicting, got %v", keys)
+	}
+
+	// the running work finishes, but the queued work fails instead of running
+	close(release)
+	if err := <-running; err != nil {
+		t.Fatalf("unexpected error from the running work: %s", err)
+	}
+
+	if err := <-queued; err != pooled.ErrEvicted {
+		t.Fatalf("expected queued work to fail with ErrEvicted, got %v", err)
+	}
+
+	// the next request opens a new connection
+	if err := m.Do(s.Addr, func(pooled.Conn) error { return nil }); err != nil {
+		t.Fatalf("expected a new connection to be opened, got %v", err)
+	}
+
+	if info, ok := m.Conn(s.Addr); !ok || info.Served != 1 {
+		t.Fatalf("expected a new connection, got %+v", info)
+	}
+}
+
+// flaky is a NewConnection that fails while the device is down, and counts how many times it was called
+type flaky struct {
+	mu       sync.Mutex
+	down     bool
+	attempts int
+}
+
+func (f *flaky) dial(key interface{}) (pooled.Conn, error) {
+	f.mu.Lock()
+	f.attempts++
+	down := f.down
+	f.mu.Unlock()
+
+	if down {
+		return nil, errors.New("connection refused")
+	}
+
+	return pooled.DialTCP(time.Second)(key)
+}
+
+func (f *flaky) set(down bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.down = down
+}
+
+func (f *flaky) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.attempts
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	s := pooledtest.NewServer()
+	defer s.Close()
+
+	f := &flaky{down: true}
+	m := pooled.New(f.dial, pooled.WithCircuitBreaker(pooled.CircuitBreaker{Threshold: 2, Cooldown: 50 * time.Millisecond}))
+	defer m.Close(context.Background())
+
+	nop := func(pooled.Conn) error { return nil }
+
+	for i := 0; i < 2; i++ {
+		if err := m.Do(s.Addr, nop); err == nil {
+			t.Fatalf("expected opening a connection to fail")
+		} else if _, ok := err.(*pooled.CircuitOpenError); ok {
+			t.Fatalf("expected the breaker to be closed before the threshold, got %v", err)
+		}
+	}
+
+	// the breaker is open, so requests fail without trying to connect
+	if err := m.Do(s.Addr, nop); err == nil {
+		t.Fatalf("expected the breaker to reject the request")
+	} else if cerr, ok := err.(*pooled.CircuitOpenError); !ok || cerr.Err == nil || cerr.Until.IsZero() {
+		t.Fatalf("expected a *CircuitOpenError, got %v", err)
+	}
+
+	if !m.CircuitOpen(s.Addr) || f.count() != 2 {
+		t.Fatalf("expected the breaker to be open after 2 attempts, got %v attempts", f.count())
+	}
+
+	// once the cooldown passes, the breaker is half-open and lets one request try again
+	time.Sleep(60 * time.Millisecond)
+
+	if m.CircuitOpen(s.Addr) {
+		t.Fatalf("expected the breaker to be half-open after the cooldown")
+	}
+
+	if err := m.Do(s.Addr, nop); err == nil || f.count() != 3 {
+		t.Fatalf("expected the probe to fail after 3 attempts, got %v after %v attempts", err, f.count())
+	}
+
+	if !m.CircuitOpen(s.Addr) {
+		t.Fatalf("expected a failed probe to open the breaker again")
+	}
+
+	// a successful probe resets it
+	time.Sleep(60 * time.Millisecond)
+	f.set(false)
+
+	if err := m.Do(s.Addr, nop); err != nil {
+		t.Fatalf("expected the probe to succeed, got %v", err)
+	}
+
+	if m.CircuitOpen(s.Addr) {
+		t.Fatalf("expected a successful probe to close the breaker")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	s := pooledtest.NewServer()
+	defer s.Close()
+
+	f := &flaky{down: true}
+	m := pooled.New(f.dial, pooled.WithBackoff(pooled.Backoff{Initial: 100 * time.Millisecond, Max: 100 * time.Millisecond}))
+	defer m.Close(context.Background())
+
+	nop := func(pooled.Conn) error { return nil }
+
+	if err := m.Do(s.Addr, nop); err == nil {
+		t.Fatalf("expected opening a connection to fail")
+	}
+
+	// giving up while waiting out the backoff doesn't count as another attempt
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if err := m.DoContext(ctx, s.Addr, nop); err == nil {
+		t.Fatalf("expected the request to time out during the backoff")
+	} else if terr, ok := err.(*pooled.TimeoutError); !ok || !terr.Queued || f.count() != 1 {
+		t.Fatalf("expected a queued timeout after 1 attempt, got %v after %v attempts", err, f.count())
+	}
+
+	f.set(false)
+	start := time.Now()
+
+	if err := m.Do(s.Addr, nop); err != nil {
+		t.Fatalf("expected the next attempt to succeed, got %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Fatalf("expected the next attempt to wait out the backoff, but it only took %v", elapsed)
+	}
+}
+
+func TestMaxConns(t *testing.T) {
+	s1 := pooledtest.NewServer()
+	defer s1.Close()
+
+	s2 := pooledtest.NewServer()
+	defer s2.Close()
+
+	m := pooled.New(pooled.DialTCP(time.Second), pooled.WithMaxConns(1), pooled.WithTTL(100*time.Millisecond))
+	defer m.Close(context.Background())
+
+	nop := func(pooled.Conn) error { return nil }
+
+	if err := m.Do(s1.Addr, nop); err != nil {
+		t.Fatalf("failed to open the first connection: %s", err)
+	}
+
+	// a second connection has to wait for the first one to close
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if err := m.DoContext(ctx, s2.Addr, nop); err == nil {
+		t.Fatalf("expected the second connection to wait for a free slot")
+	} else if terr, ok := err.(*pooled.TimeoutError); !ok || !terr.Queued {
+		t.Fatalf("expected a queued timeout, got %v", err)
+	}
+
+	// which it gets once the first one hits its ttl
+	if err := m.Do(s2.Addr, nop); err != nil {
+		t.Fatalf("failed to open the second connection: %s", err)
+	}
+
+	if keys := m.Keys(); len(keys) != 1 || keys[0] != s2.Addr {
+		t.Fatalf("expected only a connection to %v, got %v", s2.Addr, keys)
+	}
+}
+
+func TestKeepAlive(t *testing.T) {
+	s := pooledtest.NewServer(
+		pooledtest.ExpectString("PING\n"),
+		pooledtest.RespondString("PONG\n"),
+		pooledtest.ExpectString("PING\n"),
+		pooledtest.RespondString("PONG\n"),
+	)
+	defer s.Close()
+
+	var resp string
+	m := pooled.New(pooled.DialTCP(time.Second), pooled.WithKeepAlive(50*time.Millisecond, request("PING\n", &resp, time.Second)))
+
+	if err := m.Do(s.Addr, func(pooled.Conn) error { return nil }); err != nil {
+		t.Fatalf("failed to open a connection: %s", err)
+	}
+
+	for deadline := time.Now().Add(time.Second); !s.Done(); {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for keep alives")
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	m.Close(context.Background())
+
+	if err := s.Close(); err != nil {
+		t.Fatalf("unexpected server errors: %s", err)
+	}
+}
+
+func TestKeepAliveTTL(t *testing.T) {
+	s := pooledtest.NewServer()
+	defer s.Close()
+
+	var mu sync.Mutex
+	sent := 0
+
+	keepAlive := func(pooled.Conn) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		sent++
+		return nil
+	}
+
+	m := pooled.New(pooled.DialTCP(time.Second), pooled.WithTTL(100*time.Millisecond), pooled.WithKeepAlive(20*time.Millisecond, keepAlive))
+	defer m.Close(context.Background())
+
+	if err := m.Do(s.Addr, func(pooled.Conn) error { return nil }); err != nil {
+		t.Fatalf("failed to open a connection: %s", err)
+	}
+
+	// keep alives don't keep the connection open past its ttl
+	waitForClose(t, m, s.Addr)
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if sent < 2 {
+		t.Fatalf("expected keep alives while the connection was idle, got %v", sent)
+	}
+}
+
+// block runs work on the connection for key that doesn't finish until the returned func is called, so that more work can be queued behind it
+func block(t *testing.T, m *pooled.Map, key interface{}) func() {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	done := make(chan error, 1)
+
+	go func() {
+		done <- m.Do(key, func(pooled.Conn) error {
+			close(started)
+			<-release
+			return nil
+		})
+	}()
+
+	<-started
+
+	return func() {
+		close(release)
+		if err := <-done; err != nil {
+			t.Fatalf("unexpected error from blocking work: %s", err)
+		}
+	}
+}
+
+// waitForQueue waits for n requests to be queued on the connection for key
+func waitForQueue(t *testing.T, m *pooled.Map, key interface{}, n int) {
+	deadline := time.Now().Add(time.Second)
+	for {
+		if info, _ := m.Conn(key); info.QueueDepth == n {
+			return
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %v requests to be queued", n)
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestPriority(t *testing.T) {
+	s := pooledtest.NewServer()
+	defer s.Close()
+
+	m := pooled.New(pooled.DialTCP(time.Second))
+	defer m.Close(context.Background())
+
+	release := block(t, m, s.Addr)
+
+	var mu sync.Mutex
+	var order []string
+	var wg sync.WaitGroup
+
+	queue := func(ctx context.Context, name string) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			m.DoContext(ctx, s.Addr, func(pooled.Conn) error {
+				mu.Lock()
+				defer mu.Unlock()
+
+				order = append(order, name)
+				return nil
+			})
+		}()
+	}
+
+	// background work queued first still runs after user work
+	background := pooled.ContextWithPriority(context.Background(), pooled.PriorityBackground)
+	queue(background, "poll")
+	waitForQueue(t, m, s.Addr, 1)
+
+	queue(context.Background(), "power")
+	waitForQueue(t, m, s.Addr, 2)
+
+	release()
+	wg.Wait()
+
+	if len(order) != 2 || order[0] != "power" || order[1] != "poll" {
+		t.Fatalf("expected user work to run before background work, got %v", order)
+	}
+}
+
+func TestCoalesce(t *testing.T) {
+	s := pooledtest.NewServer(
+		pooledtest.ExpectString("PWR?\n"),
+		pooledtest.RespondString("ON\n"),
+	)
+	defer s.Close()
+
+	m := pooled.New(pooled.DialTCP(time.Second))
+	defer m.Close(context.Background())
+
+	release := block(t, m, s.Addr)
+
+	query := func(conn pooled.Conn) (interface{}, error) {
+		var resp string
+		err := request("PWR?\n", &resp, time.Second)(conn)
+		return resp, err
+	}
+
+	type result struct {
+		val interface{}
+		err error
+	}
+
+	// both callers share the same query, so the device is only asked once
+	results := make(chan result, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			val, err := m.DoCoalesced(context.Background(), s.Addr, "power", query)
+			results <- result{val, err}
+		}()
+	}
+
+	waitForQueue(t, m, s.Addr, 1)
+	time.Sleep(10 * time.Millisecond)
+
+	release()
+
+	for i := 0; i < 2; i++ {
+		if r := <-results; r.err != nil || r.val != "ON\n" {
+			t.Fatalf("expected ON, got %v (err: %v)", r.val, r.err)
+		}
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatalf("unexpected server errors: %s", err)
+	}
+}
+
+func TestCoalesceRejoin(t *testing.T) {
+	s := pooledtest.NewServer()
+	defer s.Close()
+
+	m := pooled.New(pooled.DialTCP(time.Second))
+	defer m.Close(context.Background())
+
+	release := block(t, m, s.Addr)
+
+	var mu sync.Mutex
+	var ran []string
+
+	query := func(name string) pooled.Query {
+		return func(pooled.Conn) (interface{}, error) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			ran = append(ran, name)
+			return name, nil
+		}
+	}
+
+	// the only caller gives up while the query is queued, which abandons it
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if _, err := m.DoCoalesced(ctx, s.Addr, "power", query("first")); err == nil {
+		t.Fatalf("expected the first query to time out")
+	} else if terr, ok := err.(*pooled.TimeoutError); !ok || !terr.Queued {
+		t.Fatalf("expected a queued timeout, got %v", err)
+	}
+
+	// so the next caller starts a new query instead of joining the abandoned one
+	done := make(chan error, 1)
+	var val interface{}
+
+	go func() {
+		var err error
+		val, err = m.DoCoalesced(context.Background(), s.Addr, "power", query("second"))
+		done <- err
+	}()
+
+	waitForQueue(t, m, s.Addr, 2)
+	release()
+
+	if err := <-done; err != nil || val != "second" {
+		t.Fatalf("expected the second query to run, got %v (err: %v)", val, err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if len(ran) != 1 || ran[0] != "second" {
+		t.Fatalf("expected only the second query to run, got %v", ran)
+	}
+}
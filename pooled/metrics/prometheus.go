@@ -0,0 +1,150 @@
+package metrics
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/byuoitav/common/pooled"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// PrometheusObserver is a pooled.Observer that records prometheus metrics for each key in a pooled.Map.
+type PrometheusObserver struct {
+	opened       *prometheus.CounterVec
+	openFailures *prometheus.CounterVec
+	closed       *prometheus.CounterVec
+	open         *prometheus.GaugeVec
+	connAge      *prometheus.HistogramVec
+	queueWait    *prometheus.HistogramVec
+	workDuration *prometheus.HistogramVec
+	workErrors   *prometheus.CounterVec
+	drainedBytes *prometheus.CounterVec
+	errors       *prometheus.CounterVec
+}
+
+// NewPrometheusObserver creates an observer whose metrics are named namespace_pooled_*, and registers them with reg.
+func NewPrometheusObserver(namespace string, reg prometheus.Registerer) (*PrometheusObserver, error) {
+	labels := []string{"key"}
+
+	o := &PrometheusObserver{
+		opened: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "pooled",
+			Name:      "connections_opened_total",
+			Help:      "Number of connections opened.",
+		}, labels),
+		openFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "pooled",
+			Name:      "connection_open_failures_total",
+			Help:      "Number of times a connection failed to open.",
+		}, labels),
+		closed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "pooled",
+			Name:      "connections_closed_total",
+			Help:      "Number of connections closed.",
+		}, labels),
+		open: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "pooled",
+			Name:      "open_connections",
+			Help:      "Number of connections currently open.",
+		}, labels),
+		connAge: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "pooled",
+			Name:      "connection_age_seconds",
+			Help:      "How long connections were open before they were closed.",
+			Buckets:   prometheus.ExponentialBuckets(1, 4, 8),
+		}, labels),
+		queueWait: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "pooled",
+			Name:      "queue_wait_seconds",
+			Help:      "How long work waited in the queue before it started.",
+			Buckets:   prometheus.DefBuckets,
+		}, labels),
+		workDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "pooled",
+			Name:      "work_duration_seconds",
+			Help:      "How long work took to run.",
+			Buckets:   prometheus.DefBuckets,
+		}, labels),
+		workErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "pooled",
+			Name:      "work_errors_total",
+			Help:      "Number of times work returned an error.",
+		}, labels),
+		drainedBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "pooled",
+			Name:      "drained_bytes_total",
+			Help:      "Number of leftover bytes emptied out of the read buffer between requests.",
+		}, labels),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "pooled",
+			Name:      "errors_total",
+			Help:      "Number of errors that happened outside of work.",
+		}, labels),
+	}
+
+	collectors := []prometheus.Collector{o.opened, o.openFailures, o.closed, o.open, o.connAge, o.queueWait, o.workDuration, o.workErrors, o.drainedBytes, o.errors}
+	for _, c := range collectors {
+		if err := reg.Register(c); err != nil {
+			return nil, fmt.Errorf("unable to register pooled metrics: %s", err)
+		}
+	}
+
+	return o, nil
+}
+
+func label(key interface{}) string {
+	return fmt.Sprintf("%v", key)
+}
+
+// ConnOpened .
+func (o *PrometheusObserver) ConnOpened(key interface{}) {
+	o.opened.WithLabelValues(label(key)).Inc()
+	o.open.WithLabelValues(label(key)).Inc()
+}
+
+// ConnOpenFailed .
+func (o *PrometheusObserver) ConnOpenFailed(key interface{}, err error) {
+	o.openFailures.WithLabelValues(label(key)).Inc()
+}
+
+// ConnClosed .
+func (o *PrometheusObserver) ConnClosed(key interface{}, age time.Duration) {
+	o.closed.WithLabelValues(label(key)).Inc()
+	o.open.WithLabelValues(label(key)).Dec()
+	o.connAge.WithLabelValues(label(key)).Observe(age.Seconds())
+}
+
+// WorkStarted .
+func (o *PrometheusObserver) WorkStarted(key interface{}, queueWait time.Duration) {
+	o.queueWait.WithLabelValues(label(key)).Observe(queueWait.Seconds())
+}
+
+// WorkFinished .
+func (o *PrometheusObserver) WorkFinished(key interface{}, duration time.Duration, err error) {
+	o.workDuration.WithLabelValues(label(key)).Observe(duration.Seconds())
+	if err != nil {
+		o.workErrors.WithLabelValues(label(key)).Inc()
+	}
+}
+
+// BytesDrained .
+func (o *PrometheusObserver) BytesDrained(key interface{}, n int) {
+	o.drainedBytes.WithLabelValues(label(key)).Add(float64(n))
+}
+
+// Error .
+func (o *PrometheusObserver) Error(key interface{}, err error) {
+	o.errors.WithLabelValues(label(key)).Inc()
+}
+
+var _ pooled.Observer = &PrometheusObserver{}
@@ -0,0 +1,51 @@
+package pooled
+
+import "time"
+
+// Observer is told about everything that happens in a Map, e.g. to collect metrics. Its methods are called from the map's goroutines, so they must be safe for concurrent use and should return quickly.
+type Observer interface {
+	// ConnOpened is called after a new connection is opened for key
+	ConnOpened(key interface{})
+
+	// ConnOpenFailed is called when a new connection for key couldn't be opened
+	ConnOpenFailed(key interface{}, err error)
+
+	// ConnClosed is called after the connection for key is closed, with how long it was open
+	ConnClosed(key interface{}, age time.Duration)
+
+	// WorkStarted is called right before work runs, with how long it waited in the queue
+	WorkStarted(key interface{}, queueWait time.Duration)
+
+	// WorkFinished is called after work runs, with how long it took and the error it returned
+	WorkFinished(key interface{}, duration time.Duration, err error)
+
+	// BytesDrained is called when leftover bytes are emptied out of the read buffer before the next request
+	BytesDrained(key interface{}, n int)
+
+	// Error is called for errors that happen outside of work, e.g. failing to empty the read buffer or abandoning a queued request
+	Error(key interface{}, err error)
+}
+
+// NopObserver is an Observer that does nothing. Embed it to only implement part of Observer.
+type NopObserver struct{}
+
+// ConnOpened .
+func (NopObserver) ConnOpened(key interface{}) {}
+
+// ConnOpenFailed .
+func (NopObserver) ConnOpenFailed(key interface{}, err error) {}
+
+// ConnClosed .
+func (NopObserver) ConnClosed(key interface{}, age time.Duration) {}
+
+// WorkStarted .
+func (NopObserver) WorkStarted(key interface{}, queueWait time.Duration) {}
+
+// WorkFinished .
+func (NopObserver) WorkFinished(key interface{}, duration time.Duration, err error) {}
+
+// BytesDrained .
+func (NopObserver) BytesDrained(key interface{}, n int) {}
+
+// Error .
+func (NopObserver) Error(key interface{}, err error) {}
@@ -0,0 +1,143 @@
+package pooled
+
+import (
+	"crypto/tls"
+	"time"
+)
+
+const (
+	// DefaultTTL is how long a connection made by New stays open without any work, unless WithTTL is used
+	DefaultTTL = 30 * time.Second
+
+	// DefaultQueueSize is how many requests can be queued for a single key, unless WithQueueSize is used
+	DefaultQueueSize = 10
+)
+
+// Option configures a Map created with New, or an HTTPMap created with NewHTTPMap. Options that only make sense for one of them are ignored by the other.
+type Option func(*options)
+
+type options struct {
+	ttl         time.Duration
+	delay       time.Duration
+	queueSize   int
+	maxConns    int
+	openTimeout time.Duration
+
+	keepAlive         Work
+	keepAliveInterval time.Duration
+
+	observer Observer
+	backoff  Backoff
+	breaker  CircuitBreaker
+
+	// these only apply to an HTTPMap
+	concurrency   int
+	login         Login
+	httpKeepAlive HTTPWork
+	tlsConfig     *tls.Config
+}
+
+// WithTTL sets how long a connection stays open without any work before it is closed.
+func WithTTL(ttl time.Duration) Option {
+	return func(o *options) {
+		o.ttl = ttl
+	}
+}
+
+// WithDelay sets how long to wait between each request on a connection.
+func WithDelay(delay time.Duration) Option {
+	return func(o *options) {
+		o.delay = delay
+	}
+}
+
+// WithQueueSize sets how many requests can be waiting for a single connection before more requests block.
+func WithQueueSize(size int) Option {
+	return func(o *options) {
+		if size < 0 {
+			size = 0
+		}
+
+		o.queueSize = size
+	}
+}
+
+// WithMaxConns limits how many connections can be open at once, across every key. Requests that need a new connection wait for another one to close. A max <= 0 means no limit.
+func WithMaxConns(max int) Option {
+	return func(o *options) {
+		o.maxConns = max
+	}
+}
+
+// WithOpenTimeout sets how long to wait for a new connection to open before giving up.
+func WithOpenTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.openTimeout = timeout
+	}
+}
+
+// WithKeepAlive runs work on a connection every time it has been idle for interval, e.g. to keep a device from dropping the session. Running it doesn't count as activity for the connection's ttl.
+func WithKeepAlive(interval time.Duration, work Work) Option {
+	return func(o *options) {
+		o.keepAliveInterval = interval
+		o.keepAlive = work
+	}
+}
+
+// WithObserver sets the observer that is told about everything that happens in the map.
+func WithObserver(observer Observer) Option {
+	return func(o *options) {
+		if observer == nil {
+			observer = NopObserver{}
+		}
+
+		o.observer = observer
+	}
+}
+
+// WithBackoff sets how long to wait between failed attempts to open a connection for a key.
+func WithBackoff(b Backoff) Option {
+	return func(o *options) {
+		o.backoff = b
+	}
+}
+
+// WithCircuitBreaker sets when to stop trying to open connections for a key that keeps failing.
+func WithCircuitBreaker(cb CircuitBreaker) Option {
+	return func(o *options) {
+		o.breaker = cb
+	}
+}
+
+// WithConcurrency sets how many requests an HTTPMap sends to a single device at once. The default is 1, so requests to a device are serialized like they are on a Map.
+func WithConcurrency(n int) Option {
+	return func(o *options) {
+		if n < 1 {
+			n = 1
+		}
+
+		o.concurrency = n
+	}
+}
+
+// WithLogin sets how an HTTPMap logs into a device. It's called before the first request to a device, and again if the device says the session has expired.
+func WithLogin(login Login) Option {
+	return func(o *options) {
+		o.login = login
+	}
+}
+
+// WithHTTPKeepAlive runs work on a device's HTTP session every time it has been idle for interval, e.g. to keep the device from expiring the login. Like WithKeepAlive, running it doesn't count as activity for the session's ttl.
+func WithHTTPKeepAlive(interval time.Duration, work HTTPWork) Option {
+	return func(o *options) {
+		o.keepAliveInterval = interval
+		o.httpKeepAlive = work
+	}
+}
+
+// WithTLSConfig sets the TLS configuration an HTTPMap uses for https devices, e.g. to trust a device's self-signed certificate.
+func WithTLSConfig(config *tls.Config) Option {
+	return func(o *options) {
+		o.tlsConfig = config
+	}
+}
@@ -0,0 +1,339 @@
+// Package pooledtest provides a scripted fake device for testing Work functions written for pooled.Map, without needing the real hardware.
+package pooledtest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultTimeout is how long an expectation waits for a request before failing.
+const DefaultTimeout = 5 * time.Second
+
+// errDisconnect is returned by the Disconnect step to drop the current connection
+var errDisconnect = errors.New("disconnect")
+
+// Step is a single thing the fake device does, like waiting for a request or sending a response.
+type Step struct {
+	desc string
+	run  func(s *Server, c net.Conn) error
+}
+
+func (s Step) String() string {
+	return s.desc
+}
+
+// Expect waits for the client to send exactly req.
+func Expect(req []byte) Step {
+	return Step{
+		desc: fmt.Sprintf("expect %q", req),
+		run: func(s *Server, c net.Conn) error {
+			c.SetReadDeadline(time.Now().Add(s.Timeout))
+			defer c.SetReadDeadline(time.Time{})
+
+			buf := make([]byte, len(req))
+			n, err := io.ReadFull(c, buf)
+			switch {
+			case n == 0 && err == io.EOF:
+				return err
+			case err != nil && n == 0:
+				return fmt.Errorf("never got request: %s", err)
+			case !bytes.Equal(buf[:n], req):
+				return fmt.Errorf("unexpected command %q", buf[:n])
+			}
+
+			return nil
+		},
+	}
+}
+
+// ExpectString is Expect for a string request.
+func ExpectString(req string) Step {
+	return Expect([]byte(req))
+}
+
+// Respond sends resp to the client.
+func Respond(resp []byte) Step {
+	return Step{
+		desc: fmt.Sprintf("respond %q", resp),
+		run: func(s *Server, c net.Conn) error {
+			_, err := c.Write(resp)
+			return err
+		},
+	}
+}
+
+// RespondString is Respond for a string response.
+func RespondString(resp string) Step {
+	return Respond([]byte(resp))
+}
+
+// RespondInChunks sends resp in writes of at most size bytes, waiting gap between each one, like a slow device or a busy network.
+func RespondInChunks(resp []byte, size int, gap time.Duration) Step {
+	return Step{
+		desc: fmt.Sprintf("respond %q in chunks of %d", resp, size),
+		run: func(s *Server, c net.Conn) error {
+			for len(resp) > 0 {
+				n := size
+				if n > len(resp) {
+					n = len(resp)
+				}
+
+				if _, err := c.Write(resp[:n]); err != nil {
+					return err
+				}
+
+				resp = resp[n:]
+				if len(resp) > 0 {
+					if err := s.sleep(gap); err != nil {
+						return err
+					}
+				}
+			}
+
+			return nil
+		},
+	}
+}
+
+// Delay waits for d before running the next step.
+func Delay(d time.Duration) Step {
+	return Step{
+		desc: fmt.Sprintf("delay %v", d),
+		run: func(s *Server, c net.Conn) error {
+			return s.sleep(d)
+		},
+	}
+}
+
+// Garbage sends n random bytes, like a device that was left in a bad state.
+func Garbage(n int) Step {
+	return Step{
+		desc: fmt.Sprintf("send %d garbage bytes", n),
+		run: func(s *Server, c net.Conn) error {
+			buf := make([]byte, n)
+			rand.Read(buf)
+
+			_, err := c.Write(buf)
+			return err
+		},
+	}
+}
+
+// Disconnect closes the connection. The rest of the script runs on the next connection the client opens.
+func Disconnect() Step {
+	return Step{
+		desc: "disconnect",
+		run: func(s *Server, c net.Conn) error {
+			return errDisconnect
+		},
+	}
+}
+
+// Server is a fake device listening on a local TCP port that follows a script of steps.
+// Steps run in order across every connection that is opened, and anything the client sends once the script is done is reported as an unexpected command.
+type Server struct {
+	// Addr is the address the server is listening on, which can be used as a pooled.Map key with pooled.DialTCP
+	Addr string
+
+	// Timeout is how long an expectation waits for its request
+	Timeout time.Duration
+
+	l     net.Listener
+	steps []Step
+	done  chan struct{}
+	wg    sync.WaitGroup
+
+	mu     sync.Mutex
+	next   int
+	errs   []error
+	conn   net.Conn
+	closed bool
+}
+
+// NewServer starts a server that runs steps. It panics if it can't listen on a local port.
+func NewServer(steps ...Step) *Server {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(fmt.Sprintf("pooledtest: failed to listen on a port: %s", err))
+	}
+
+	s := &Server{
+		Addr:    l.Addr().String(),
+		Timeout: DefaultTimeout,
+		l:       l,
+		steps:   steps,
+		done:    make(chan struct{}),
+	}
+
+	s.wg.Add(1)
+	go s.accept()
+
+	return s
+}
+
+func (s *Server) accept() {
+	defer s.wg.Done()
+
+	for {
+		c, err := s.l.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			c.Close()
+			return
+		}
+
+		s.conn = c
+		s.mu.Unlock()
+
+		s.serve(c)
+	}
+}
+
+func (s *Server) serve(c net.Conn) {
+	defer c.Close()
+
+	for {
+		s.mu.Lock()
+		i := s.next
+		s.mu.Unlock()
+
+		if i >= len(s.steps) {
+			break
+		}
+
+		err := s.steps[i].run(s, c)
+		switch {
+		case err == errDisconnect:
+			s.advance()
+			return
+		case s.isClosed():
+			return
+		case err == io.EOF:
+			// the client went away, so try this step again on the next connection
+			return
+		case err != nil:
+			s.fail(fmt.Errorf("step %d (%s): %s", i+1, s.steps[i], err))
+		}
+
+		s.advance()
+	}
+
+	// the script is done, so anything else is unexpected
+	buf := make([]byte, 512)
+	for {
+		n, err := c.Read(buf)
+		if n > 0 {
+			s.fail(fmt.Errorf("unexpected command %q after the script finished", buf[:n]))
+		}
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (s *Server) advance() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.next++
+}
+
+func (s *Server) fail(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.errs = append(s.errs, err)
+}
+
+func (s *Server) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.closed
+}
+
+// sleep waits for d, returning early if the server is closed
+func (s *Server) sleep(d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-s.done:
+		return errors.New("server closed")
+	}
+}
+
+// Done returns true once every step in the script has run.
+func (s *Server) Done() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.next >= len(s.steps)
+}
+
+// Errors returns the problems found so far: requests that didn't match the script, and commands sent after it finished.
+func (s *Server) Errors() []error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]error{}, s.errs...)
+}
+
+// Close stops the server and closes the open connection. It returns an error describing every problem found, including steps that never ran.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		defer s.mu.Unlock()
+		return s.err()
+	}
+
+	s.closed = true
+	close(s.done)
+
+	s.l.Close()
+	if s.conn != nil {
+		s.conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := s.next; i < len(s.steps); i++ {
+		s.errs = append(s.errs, fmt.Errorf("step %d (%s) never ran", i+1, s.steps[i]))
+	}
+
+	// only report them once
+	s.next = len(s.steps)
+	return s.err()
+}
+
+func (s *Server) err() error {
+	if len(s.errs) == 0 {
+		return nil
+	}
+
+	msgs := make([]string, 0, len(s.errs))
+	for _, err := range s.errs {
+		msgs = append(msgs, err.Error())
+	}
+
+	return errors.New(strings.Join(msgs, "; "))
+}
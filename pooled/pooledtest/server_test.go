@@ -0,0 +1,96 @@
+package pooledtest
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/byuoitav/common/pooled"
+)
+
+func getPower(conn pooled.Conn) (string, error) {
+	if _, err := conn.Write([]byte("POWER?\r")); err != nil {
+		return "", err
+	}
+
+	resp, err := conn.ReadUntil('\r', time.Second)
+	if err != nil {
+		return "", err
+	}
+
+	return string(bytes.TrimSpace(resp)), nil
+}
+
+func TestServer(t *testing.T) {
+	s := NewServer(
+		ExpectString("POWER?\r"),
+		Delay(50*time.Millisecond),
+		RespondInChunks([]byte("ON\r"), 1, 10*time.Millisecond),
+		Disconnect(),
+		ExpectString("POWER?\r"),
+		RespondString("STANDBY\r"),
+	)
+
+	m := pooled.New(pooled.DialTCP(time.Second))
+	defer m.Close(context.Background())
+
+	var power string
+	err := m.Do(s.Addr, func(conn pooled.Conn) error {
+		var err error
+		power, err = getPower(conn)
+		return err
+	})
+	if err != nil || power != "ON" {
+		t.Fatalf("expected power to be on, got %q (err: %v)", power, err)
+	}
+
+	// the device hung up, so the next request should fail and reconnect
+	for i := 0; i < 2; i++ {
+		err = m.Do(s.Addr, func(conn pooled.Conn) error {
+			var err error
+			power, err = getPower(conn)
+			return err
+		})
+		if err == nil {
+			break
+		}
+	}
+
+	if err != nil || power != "STANDBY" {
+		t.Fatalf("expected power to be standby, got %q (err: %v)", power, err)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatalf("unexpected errors: %s", err)
+	}
+}
+
+func TestServerErrors(t *testing.T) {
+	s := NewServer(
+		ExpectString("POWER?\r"),
+		RespondString("ON\r"),
+		ExpectString("INPUT?\r"),
+	)
+
+	conn, err := pooled.DialTCP(time.Second)(s.Addr)
+	if err != nil {
+		t.Fatalf("failed to connect: %s", err)
+	}
+	defer conn.Close()
+
+	if _, err := getPower(conn); err != nil {
+		t.Fatalf("failed to get power: %s", err)
+	}
+
+	conn.Write([]byte("VOLUME?\r"))
+
+	for start := time.Now(); len(s.Errors()) == 0 && time.Since(start) < time.Second; {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := s.Close(); err == nil || !strings.Contains(err.Error(), `unexpected command "VOLUME?"`) {
+		t.Fatalf("expected an unexpected command, got %v", err)
+	}
+}
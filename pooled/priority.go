@@ -0,0 +1,41 @@
+package pooled
+
+import "context"
+
+// Priority decides which queued request for a connection runs next.
+type Priority int
+
+const (
+	// PriorityUser is for requests someone is waiting on, like turning on a display. It is the default.
+	PriorityUser Priority = iota
+
+	// PriorityBackground is for requests nobody is waiting on, like status polling. They only run when no user requests are queued.
+	PriorityBackground
+)
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityUser:
+		return "user"
+	case PriorityBackground:
+		return "background"
+	default:
+		return "unknown"
+	}
+}
+
+type priorityKey struct{}
+
+// ContextWithPriority returns a copy of ctx that makes DoContext queue its work with priority p.
+func ContextWithPriority(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+// PriorityFromContext returns the priority set on ctx with ContextWithPriority, or PriorityUser if there isn't one.
+func PriorityFromContext(ctx context.Context) Priority {
+	if p, ok := ctx.Value(priorityKey{}).(Priority); ok {
+		return p
+	}
+
+	return PriorityUser
+}
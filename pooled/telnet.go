@@ -0,0 +1,187 @@
+package pooled
+
+import (
+	"sync"
+)
+
+// Telnet commands
+const (
+	telnetSE   = 240
+	telnetSB   = 250
+	telnetWILL = 251
+	telnetWONT = 252
+	telnetDO   = 253
+	telnetDONT = 254
+	telnetIAC  = 255
+)
+
+// states of the telnet parser
+const (
+	telnetData = iota
+	telnetCommand
+	telnetOption
+	telnetSubnegotiation
+	telnetSubnegotiationIAC
+)
+
+type telnetTransport struct {
+	Transport
+
+	accept map[byte]bool
+
+	// parser state, which only the reader touches
+	state int
+	cmd   byte
+	raw   []byte
+
+	writeMu sync.Mutex
+}
+
+// NewTelnetTransport handles telnet option negotiation on t, so that reads only return data from the device.
+// Options in accept are agreed to when the device asks for them, and every other option is refused. Subnegotiations are dropped, and 0xFF is escaped on writes.
+func NewTelnetTransport(t Transport, accept ...byte) Transport {
+	tt := &telnetTransport{
+		Transport: t,
+		accept:    make(map[byte]bool),
+		raw:       make([]byte, 512),
+	}
+
+	for _, opt := range accept {
+		tt.accept[opt] = true
+	}
+
+	return tt
+}
+
+func (t *telnetTransport) Read(p []byte) (int, error) {
+	// there's no room for data, so don't wait for any
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	for {
+		raw := t.raw
+		if len(p) < len(raw) {
+			raw = raw[:len(p)]
+		}
+
+		n, err := t.Transport.Read(raw)
+
+		data, rerr := t.parse(raw[:n], p)
+		if rerr != nil {
+			return data, rerr
+		}
+
+		// keep reading if everything we got was negotiation
+		if data > 0 || err != nil {
+			return data, err
+		}
+	}
+}
+
+// parse copies the data in raw into p, replying to any negotiation. raw is never longer than p.
+func (t *telnetTransport) parse(raw, p []byte) (int, error) {
+	n := 0
+
+	for _, b := range raw {
+		switch t.state {
+		case telnetData:
+			if b == telnetIAC {
+				t.state = telnetCommand
+				continue
+			}
+
+			p[n] = b
+			n++
+		case telnetCommand:
+			switch b {
+			case telnetIAC:
+				// an escaped 0xFF
+				p[n] = b
+				n++
+				t.state = telnetData
+			case telnetWILL, telnetWONT, telnetDO, telnetDONT:
+				t.cmd = b
+				t.state = telnetOption
+			case telnetSB:
+				t.state = telnetSubnegotiation
+			default:
+				// commands without an option (NOP, GA, etc.)
+				t.state = telnetData
+			}
+		case telnetOption:
+			t.state = telnetData
+
+			if err := t.negotiate(t.cmd, b); err != nil {
+				return n, err
+			}
+		case telnetSubnegotiation:
+			if b == telnetIAC {
+				t.state = telnetSubnegotiationIAC
+			}
+		case telnetSubnegotiationIAC:
+			if b == telnetSE {
+				t.state = telnetData
+			} else {
+				t.state = telnetSubnegotiation
+			}
+		}
+	}
+
+	return n, nil
+}
+
+// negotiate replies to the device asking to enable an option. Requests to disable an option aren't answered, so that we don't get stuck in a loop acknowledging each other.
+func (t *telnetTransport) negotiate(cmd, opt byte) error {
+	var reply byte
+
+	switch {
+	case cmd == telnetDO && t.accept[opt]:
+		reply = telnetWILL
+	case cmd == telnetDO:
+		reply = telnetWONT
+	case cmd == telnetWILL && t.accept[opt]:
+		reply = telnetDO
+	case cmd == telnetWILL:
+		reply = telnetDONT
+	default:
+		return nil
+	}
+
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+
+	_, err := t.Transport.Write([]byte{telnetIAC, reply, opt})
+	return err
+}
+
+func (t *telnetTransport) Write(p []byte) (int, error) {
+	escaped := make([]byte, 0, len(p))
+	for _, b := range p {
+		if b == telnetIAC {
+			escaped = append(escaped, telnetIAC)
+		}
+
+		escaped = append(escaped, b)
+	}
+
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+
+	n, err := t.Transport.Write(escaped)
+	if n >= len(escaped) {
+		return len(p), err
+	}
+
+	// count how many of p's bytes made it out
+	written := 0
+	for i := 0; i < n; i++ {
+		if escaped[i] == telnetIAC && i+1 < n && escaped[i+1] == telnetIAC {
+			i++
+		}
+
+		written++
+	}
+
+	return written, err
+}
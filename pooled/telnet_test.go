@@ -0,0 +1,97 @@
+package pooled_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/byuoitav/common/pooled"
+	"github.com/byuoitav/common/pooled/pooledtest"
+)
+
+// Telnet commands and options used in the script
+const (
+	telnetIAC  = 255
+	telnetWILL = 251
+	telnetWONT = 252
+	telnetDO   = 253
+	telnetSB   = 250
+	telnetSE   = 240
+	telnetNOP  = 241
+
+	telnetECHO  = 1
+	telnetSGA   = 3
+	telnetTTYPE = 24
+	telnetNAWS  = 31
+)
+
+func TestTelnet(t *testing.T) {
+	s := pooledtest.NewServer(
+		// the device negotiates options, and sends its terminal type subnegotiation before the banner
+		pooledtest.Respond([]byte{
+			telnetIAC, telnetDO, telnetECHO,
+			telnetIAC, telnetDO, telnetTTYPE,
+			telnetIAC, telnetWILL, telnetSGA,
+			telnetIAC, telnetWONT, telnetNAWS,
+			telnetIAC, telnetSB, telnetTTYPE, 1, telnetIAC, telnetSE,
+		}),
+		pooledtest.RespondString("Hello\xff\xff\r\n"),
+		// echo and suppress go ahead are accepted, terminal type is refused, and wont isn't answered
+		pooledtest.Expect([]byte{
+			telnetIAC, telnetWILL, telnetECHO,
+			telnetIAC, telnetWONT, telnetTTYPE,
+			telnetIAC, telnetDO, telnetSGA,
+		}),
+		// 0xFF is escaped when it's written
+		pooledtest.Expect([]byte("A\xff\xffB\n")),
+		// commands can be split across reads
+		pooledtest.RespondInChunks([]byte{'O', telnetIAC, telnetNOP, telnetIAC, telnetIAC, 'K', '\n'}, 1, 5*time.Millisecond),
+	)
+	defer s.Close()
+
+	conn, err := pooled.DialTelnet(time.Second, telnetECHO, telnetSGA)(s.Addr)
+	if err != nil {
+		t.Fatalf("failed to connect: %s", err)
+	}
+	defer conn.Close()
+
+	banner, err := conn.ReadUntil('\n', time.Second)
+	if err != nil || string(banner) != "Hello\xff\r\n" {
+		t.Fatalf("expected only the banner, got %q (err: %v)", banner, err)
+	}
+
+	if n, err := conn.Write([]byte("A\xffB\n")); err != nil || n != 4 {
+		t.Fatalf("expected to write 4 bytes, wrote %v (err: %v)", n, err)
+	}
+
+	resp, err := conn.ReadUntil('\n', time.Second)
+	if err != nil || string(resp) != "O\xffK\n" {
+		t.Fatalf("expected O\\xffK, got %q (err: %v)", resp, err)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatalf("unexpected server errors: %s", err)
+	}
+}
+
+func TestTelnetEmptyRead(t *testing.T) {
+	s := pooledtest.NewServer()
+	defer s.Close()
+
+	conn, err := pooled.DialTelnet(time.Second)(s.Addr)
+	if err != nil {
+		t.Fatalf("failed to connect: %s", err)
+	}
+	defer conn.Close()
+
+	done := make(chan struct{})
+	go func() {
+		conn.Transport().Read(nil)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("expected reading into an empty buffer to return right away")
+	}
+}
@@ -0,0 +1,232 @@
+package pooled
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Transport is the stream a Conn reads from and writes to. A net.Conn (including a *tls.Conn) is a Transport; use NewStreamTransport for streams that don't support deadlines.
+type Transport interface {
+	io.ReadWriteCloser
+
+	SetDeadline(t time.Time) error
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+}
+
+// DialTCP returns a NewConnection that opens a TCP connection to the address in key.
+func DialTCP(timeout time.Duration) NewConnection {
+	return func(key interface{}) (Conn, error) {
+		c, err := net.DialTimeout("tcp", fmt.Sprintf("%v", key), timeout)
+		if err != nil {
+			return nil, err
+		}
+
+		return Wrap(c), nil
+	}
+}
+
+// DialTLS returns a NewConnection that opens a TLS connection to the address in key.
+func DialTLS(config *tls.Config, timeout time.Duration) NewConnection {
+	return func(key interface{}) (Conn, error) {
+		dialer := &net.Dialer{Timeout: timeout}
+
+		c, err := tls.DialWithDialer(dialer, "tcp", fmt.Sprintf("%v", key), config)
+		if err != nil {
+			return nil, err
+		}
+
+		return Wrap(c), nil
+	}
+}
+
+// DialTelnet returns a NewConnection that opens a telnet session to the address in key. See NewTelnetTransport.
+func DialTelnet(timeout time.Duration, accept ...byte) NewConnection {
+	return func(key interface{}) (Conn, error) {
+		c, err := net.DialTimeout("tcp", fmt.Sprintf("%v", key), timeout)
+		if err != nil {
+			return nil, err
+		}
+
+		return WrapTransport(c.RemoteAddr().String(), NewTelnetTransport(c, accept...)), nil
+	}
+}
+
+// deadlineError is returned by a stream transport when a deadline passes
+type deadlineError struct{}
+
+func (deadlineError) Error() string   { return "i/o timeout" }
+func (deadlineError) Timeout() bool   { return true }
+func (deadlineError) Temporary() bool { return true }
+
+var _ net.Error = deadlineError{}
+
+type streamTransport struct {
+	rwc io.ReadWriteCloser
+
+	// reads is filled by a goroutine reading from rwc
+	reads   chan []byte
+	readErr error
+	buf     []byte
+
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	mu            sync.Mutex
+	readDeadline  time.Time
+	writeDeadline time.Time
+}
+
+// NewStreamTransport turns a stream without deadlines, like a serial port, into a Transport.
+// Reads happen in the background so that a read can give up at its deadline. A write that misses its deadline returns an error, but may still finish in the background.
+func NewStreamTransport(rwc io.ReadWriteCloser) Transport {
+	s := &streamTransport{
+		rwc:    rwc,
+		reads:  make(chan []byte),
+		closed: make(chan struct{}),
+	}
+
+	go s.read()
+	return s
+}
+
+func (s *streamTransport) read() {
+	defer close(s.reads)
+
+	for {
+		buf := make([]byte, 512)
+
+		n, err := s.rwc.Read(buf)
+		if n > 0 {
+			select {
+			case s.reads <- buf[:n]:
+			case <-s.closed:
+				return
+			}
+		}
+
+		if err != nil {
+			s.mu.Lock()
+			s.readErr = err
+			s.mu.Unlock()
+			return
+		}
+	}
+}
+
+func (s *streamTransport) Read(p []byte) (int, error) {
+	if len(s.buf) == 0 {
+		s.mu.Lock()
+		deadline := s.readDeadline
+		s.mu.Unlock()
+
+		var timeout <-chan time.Time
+		if !deadline.IsZero() {
+			wait := time.Until(deadline)
+			if wait <= 0 {
+				return 0, deadlineError{}
+			}
+
+			timer := time.NewTimer(wait)
+			defer timer.Stop()
+
+			timeout = timer.C
+		}
+
+		select {
+		case buf, ok := <-s.reads:
+			if !ok {
+				s.mu.Lock()
+				defer s.mu.Unlock()
+
+				return 0, s.readErr
+			}
+
+			s.buf = buf
+		case <-timeout:
+			return 0, deadlineError{}
+		case <-s.closed:
+			return 0, io.ErrClosedPipe
+		}
+	}
+
+	n := copy(p, s.buf)
+	s.buf = s.buf[n:]
+
+	return n, nil
+}
+
+func (s *streamTransport) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	deadline := s.writeDeadline
+	s.mu.Unlock()
+
+	if deadline.IsZero() {
+		return s.rwc.Write(p)
+	}
+
+	wait := time.Until(deadline)
+	if wait <= 0 {
+		return 0, deadlineError{}
+	}
+
+	type result struct {
+		n   int
+		err error
+	}
+
+	written := make(chan result, 1)
+	go func() {
+		n, err := s.rwc.Write(p)
+		written <- result{n: n, err: err}
+	}()
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case r := <-written:
+		return r.n, r.err
+	case <-timer.C:
+		return 0, deadlineError{}
+	}
+}
+
+func (s *streamTransport) Close() error {
+	err := s.rwc.Close()
+
+	s.closeOnce.Do(func() {
+		close(s.closed)
+	})
+
+	return err
+}
+
+func (s *streamTransport) SetDeadline(t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.readDeadline = t
+	s.writeDeadline = t
+	return nil
+}
+
+func (s *streamTransport) SetReadDeadline(t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.readDeadline = t
+	return nil
+}
+
+func (s *streamTransport) SetWriteDeadline(t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.writeDeadline = t
+	return nil
+}
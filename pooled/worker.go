@@ -0,0 +1,301 @@
+package pooled
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// ConnInfo describes an open connection in a Map.
+type ConnInfo struct {
+	Key        interface{}   `json:"key"`
+	Opened     time.Time     `json:"opened"`
+	Age        time.Duration `json:"age"`
+	LastUsed   time.Time     `json:"last-used,omitempty"`
+	QueueDepth int           `json:"queue-depth"`
+	Served     int           `json:"served"`
+	LastError  string        `json:"last-error,omitempty"`
+}
+
+// worker owns a single connection, and runs the work sent to it one request at a time
+type worker struct {
+	m    *Map
+	key  interface{}
+	conn Conn
+
+	// reqs is user work, and background is work that only runs when there isn't any user work queued
+	reqs       chan request
+	background chan request
+
+	// pending counts the callers that have found this worker, but haven't sent it their request yet
+	pending sync.WaitGroup
+
+	stopCh   chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+
+	mu       sync.Mutex
+	opened   time.Time
+	lastUsed time.Time
+	lastErr  error
+	served   int
+
+	// failWith is the error queued requests fail with once the worker stops. if it's nil, they are run instead
+	failWith error
+}
+
+func newWorker(m *Map, key interface{}, conn Conn) *worker {
+	return &worker{
+		m:          m,
+		key:        key,
+		conn:       conn,
+		reqs:       make(chan request, m.queueSize),
+		background: make(chan request, m.queueSize),
+		stopCh:     make(chan struct{}),
+		done:       make(chan struct{}),
+		opened:     time.Now(),
+	}
+}
+
+func (w *worker) run() {
+	defer w.close()
+
+	timer := time.NewTimer(w.m.ttl)
+	defer timer.Stop()
+
+	// keepAlive fires once the connection has been idle for the keep alive interval
+	var keepAlive <-chan time.Time
+	var keepAliveTimer *time.Timer
+	if w.m.keepAlive != nil && w.m.keepAliveInterval > 0 {
+		keepAliveTimer = time.NewTimer(w.m.keepAliveInterval)
+		defer keepAliveTimer.Stop()
+
+		keepAlive = keepAliveTimer.C
+	}
+
+	for {
+		// delay before the next command is sent
+		time.Sleep(w.m.delay)
+
+		// reset the buffer by reading everything currently in it
+		bytes, err := w.conn.EmptyReadBuffer(w.m.ttl)
+		if err != nil {
+			w.conn.Log().Warnf("failed to empty buffer: %s", err)
+			w.m.observer.Error(w.key, err)
+			return
+		}
+		if len(bytes) > 0 {
+			w.conn.Log().Debugf("Read %v leftover bytes: 0x%x", len(bytes), bytes)
+			w.m.observer.BytesDrained(w.key, len(bytes))
+		}
+
+		// reset the deadlines
+		w.conn.SetDeadline(time.Time{})
+
+		var req request
+
+		// user work always goes first
+		select {
+		case req = <-w.reqs:
+		default:
+			select {
+			case req = <-w.reqs:
+			case req = <-w.background:
+			case <-keepAlive:
+				w.conn.Log().Debugf("Sending keep alive")
+
+				err := w.do(request{ctx: context.Background(), work: w.m.keepAlive, queued: time.Now()})
+				if err != nil {
+					w.conn.Log().Warnf("keep alive failed: %s", err)
+					w.m.observer.Error(w.key, err)
+
+					if shouldClose(err) {
+						return
+					}
+				}
+
+				keepAliveTimer.Reset(w.m.keepAliveInterval)
+				continue
+			case <-timer.C:
+				return
+			case <-w.stopCh:
+				return
+			}
+		}
+
+		err = w.do(req)
+		req.resp <- err
+		if shouldClose(err) {
+			// if it was a timeout error, close the connection
+			w.conn.Log().Warnf("closing connection due to non-temporary or timeout error: %s", err.Error())
+			w.m.observer.Error(w.key, err)
+			return
+		}
+
+		// reset the timers
+		if !timer.Stop() {
+			<-timer.C
+		}
+		timer.Reset(w.m.ttl)
+
+		if keepAliveTimer != nil {
+			if !keepAliveTimer.Stop() {
+				<-keepAliveTimer.C
+			}
+			keepAliveTimer.Reset(w.m.keepAliveInterval)
+		}
+	}
+}
+
+// stop tells the worker to close its connection. If err is nil, queued work is finished first; otherwise the connection is closed right away and queued work fails with err.
+func (w *worker) stop(err error) {
+	if err != nil {
+		w.mu.Lock()
+		w.failWith = err
+		w.mu.Unlock()
+	}
+
+	w.stopOnce.Do(func() {
+		close(w.stopCh)
+	})
+
+	if err != nil {
+		w.conn.Close()
+	}
+}
+
+func (w *worker) close() {
+	w.m.remove(w)
+	w.conn.Log().Infof("Closing connection")
+
+	// wait for everyone that found this worker to hand over their request
+	sent := make(chan struct{})
+	go func() {
+		w.pending.Wait()
+		close(sent)
+	}()
+
+	for finished := false; !finished; {
+		select {
+		case req := <-w.reqs:
+			w.finish(req)
+		case req := <-w.background:
+			w.finish(req)
+		case <-sent:
+			finished = true
+		}
+	}
+
+	// finish up remaining requests
+	for len(w.reqs) > 0 {
+		w.finish(<-w.reqs)
+	}
+
+	for len(w.background) > 0 {
+		w.finish(<-w.background)
+	}
+
+	w.conn.Close()
+	w.m.release()
+	w.m.observer.ConnClosed(w.key, time.Since(w.opened))
+	close(w.done)
+}
+
+// failed returns the error the worker was stopped with, if any
+func (w *worker) failed() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.failWith
+}
+
+// finish runs a request that was queued when the worker stopped, or fails it if the worker was stopped with an error
+func (w *worker) finish(req request) {
+	if err := w.failed(); err != nil {
+		req.resp <- err
+		return
+	}
+
+	req.resp <- w.do(req)
+	time.Sleep(w.m.delay)
+}
+
+// do runs a single request on the connection, unless its context is already done or the worker was stopped with an error
+func (w *worker) do(req request) error {
+	if err := w.failed(); err != nil {
+		return err
+	}
+
+	if err := req.ctx.Err(); err != nil {
+		w.conn.Log().Debugf("Skipping queued request: %s", err)
+
+		terr := &TimeoutError{Key: w.key, Queued: true, Err: err}
+		w.m.observer.Error(w.key, terr)
+		return terr
+	}
+
+	if req.started != nil {
+		req.started()
+	}
+
+	start := time.Now()
+	w.m.observer.WorkStarted(w.key, start.Sub(req.queued))
+
+	if deadline, ok := req.ctx.Deadline(); ok {
+		w.conn.SetMaxDeadline(deadline)
+	}
+
+	// if the context is canceled while the work is running, cut off any reads/writes
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+
+		select {
+		case <-req.ctx.Done():
+			w.conn.SetMaxDeadline(time.Now())
+		case <-done:
+		}
+	}()
+
+	err := req.work(w.conn)
+
+	close(done)
+	<-stopped
+
+	w.m.observer.WorkFinished(w.key, time.Since(start), err)
+
+	// clear the deadline before the next request runs
+	w.conn.SetMaxDeadline(time.Time{})
+
+	w.mu.Lock()
+	w.served++
+	w.lastUsed = time.Now()
+	if err != nil {
+		w.lastErr = err
+	}
+	w.mu.Unlock()
+
+	return err
+}
+
+func (w *worker) info() ConnInfo {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	info := ConnInfo{
+		Key:        w.key,
+		Opened:     w.opened,
+		Age:        time.Since(w.opened),
+		LastUsed:   w.lastUsed,
+		QueueDepth: len(w.reqs) + len(w.background),
+		Served:     w.served,
+	}
+
+	if w.lastErr != nil {
+		info.LastError = w.lastErr.Error()
+	}
+
+	return info
+}
@@ -0,0 +1,237 @@
+package statedefinition
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/byuoitav/common/v2/events"
+)
+
+// field is a StaticDevice field that an event can set
+type field struct {
+	index     int
+	updateKey string
+}
+
+// fields maps the json name of each StaticDevice field that an event can set to the field
+var fields = buildFields()
+
+// updateKeys are the fields whose key in UpdateTimes isn't their json name, to match CompareDevices
+var updateKeys = map[string]string{
+	"BatteryChargeHoursMinutes": "battery-chage-hours-minutes",
+	"ViewDashboard":             "view-dashboard",
+}
+
+// unsettable are the fields an event can't set directly
+var unsettable = map[string]bool{
+	"DeviceID":    true,
+	"Alerts":      true,
+	"Tags":        true,
+	"UpdateTimes": true,
+}
+
+func buildFields() map[string]field {
+	fields := make(map[string]field)
+
+	t := reflect.TypeOf(StaticDevice{})
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if unsettable[f.Name] {
+			continue
+		}
+
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if len(name) == 0 || name == "-" {
+			continue
+		}
+
+		updateKey := name
+		if key, ok := updateKeys[f.Name]; ok {
+			updateKey = key
+		}
+
+		fields[name] = field{index: i, updateKey: updateKey}
+	}
+
+	return fields
+}
+
+// DefaultEventAliases are event keys that mean the same thing as a StaticDevice field with a different name.
+var DefaultEventAliases = map[string]string{
+	"mute":              "muted",
+	"blank":             "blanked",
+	"signal":            "active-signal",
+	"battery":           "battery-charge-percentage",
+	"battery-bars":      "battery-charge-bars",
+	"battery-minutes":   "battery-charge-minutes",
+	"battery-remaining": "battery-charge-hours-minutes",
+	"user-count":        "current-user-count",
+	"lamp":              "lamp-hours",
+}
+
+// UnknownKeyError is returned when an event's key doesn't match any StaticDevice field.
+type UnknownKeyError struct {
+	Key string
+}
+
+func (e *UnknownKeyError) Error() string {
+	return fmt.Sprintf("no device field for event key %q", e.Key)
+}
+
+// EventTranslator applies events to StaticDevices. The zero value uses DefaultEventAliases.
+// An event's key is matched to a StaticDevice field by its json name (e.g. power, input, volume, muted, blanked, active-signal, battery-charge-percentage), after looking it up in Aliases.
+type EventTranslator struct {
+	Aliases map[string]string
+}
+
+// ApplyEvent applies e to dev with the default translator. See EventTranslator.Apply.
+func ApplyEvent(dev *StaticDevice, e events.Event) error {
+	return EventTranslator{}.Apply(dev, e)
+}
+
+// EventToStaticDevice creates a StaticDevice with only the changes from e, ready to be compared to the current state with CompareDevices.
+func EventToStaticDevice(e events.Event) (StaticDevice, error) {
+	var dev StaticDevice
+	err := ApplyEvent(&dev, e)
+	return dev, err
+}
+
+// Apply sets the field e's key refers to on dev, converting e's value to the field's type, and records the event's timestamp in UpdateTimes so that CompareDevices picks it up.
+// If dev doesn't have an ID yet, it's filled in (along with its building and room) from e's target device. A heartbeat or user generated event also updates LastHeartbeat or LastUserInput.
+// An event whose key doesn't match a field returns an *UnknownKeyError, but still updates those fields.
+func (t EventTranslator) Apply(dev *StaticDevice, e events.Event) error {
+	ts := e.Timestamp
+	if ts.IsZero() {
+		ts = time.Now()
+	}
+
+	target := e.TargetDevice
+	if len(target.DeviceID) > 0 {
+		target = events.GenerateBasicDeviceInfo(target.DeviceID)
+	}
+
+	switch {
+	case len(dev.DeviceID) == 0 && len(target.DeviceID) > 0:
+		dev.DeviceID = target.DeviceID
+		setUpdateTime(dev, "deviceID", ts)
+
+		if len(target.BuildingID) > 0 {
+			dev.Building = target.BuildingID
+			dev.Room = target.RoomID
+			setUpdateTime(dev, "building", ts)
+			setUpdateTime(dev, "room", ts)
+		}
+	case len(target.DeviceID) > 0 && !strings.EqualFold(dev.DeviceID, target.DeviceID):
+		return fmt.Errorf("event is for %s, not %s", target.DeviceID, dev.DeviceID)
+	}
+
+	if events.ContainsAllTags(e, events.Heartbeat) {
+		dev.LastHeartbeat = ts
+		setUpdateTime(dev, "last-heartbeat", ts)
+	}
+
+	if events.ContainsAllTags(e, events.UserGenerated) {
+		dev.LastUserInput = ts
+		setUpdateTime(dev, "last-user-input", ts)
+	}
+
+	key := strings.ToLower(strings.TrimSpace(e.Key))
+
+	aliases := t.Aliases
+	if aliases == nil {
+		aliases = DefaultEventAliases
+	}
+
+	if alias, ok := aliases[key]; ok {
+		key = alias
+	}
+
+	f, ok := fields[key]
+	if !ok {
+		return &UnknownKeyError{Key: e.Key}
+	}
+
+	val := reflect.ValueOf(dev).Elem().Field(f.index)
+
+	parsed, err := parseValue(val.Type(), e.Value)
+	if err != nil {
+		return fmt.Errorf("invalid value %q for %s: %s", e.Value, key, err)
+	}
+
+	val.Set(parsed)
+	setUpdateTime(dev, f.updateKey, ts)
+
+	dev.LastStateReceived = ts
+	setUpdateTime(dev, "last-state-received", ts)
+
+	return nil
+}
+
+func setUpdateTime(dev *StaticDevice, key string, ts time.Time) {
+	if dev.UpdateTimes == nil {
+		dev.UpdateTimes = make(map[string]time.Time)
+	}
+
+	if ts.After(dev.UpdateTimes[key]) {
+		dev.UpdateTimes[key] = ts
+	}
+}
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	boolPtrType  = reflect.TypeOf((*bool)(nil))
+	intPtrType   = reflect.TypeOf((*int)(nil))
+	floatPtrType = reflect.TypeOf((*float64)(nil))
+)
+
+// parseValue converts an event's value to typ
+func parseValue(typ reflect.Type, s string) (reflect.Value, error) {
+	s = strings.TrimSpace(s)
+
+	switch typ {
+	case timeType:
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+
+		return reflect.ValueOf(t), nil
+	case boolPtrType:
+		var b bool
+
+		switch strings.ToLower(s) {
+		case "true", "on", "yes", "1":
+			b = true
+		case "false", "off", "no", "0":
+			b = false
+		default:
+			return reflect.Value{}, fmt.Errorf("must be true or false")
+		}
+
+		return reflect.ValueOf(&b), nil
+	case intPtrType:
+		f, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("must be a number")
+		}
+
+		i := int(f)
+		return reflect.ValueOf(&i), nil
+	case floatPtrType:
+		f, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("must be a number")
+		}
+
+		return reflect.ValueOf(&f), nil
+	}
+
+	if typ.Kind() == reflect.String {
+		return reflect.ValueOf(s).Convert(typ), nil
+	}
+
+	return reflect.Value{}, fmt.Errorf("unsupported field type %v", typ)
+}
@@ -0,0 +1,59 @@
+package statedefinition
+
+import (
+	"testing"
+	"time"
+
+	"github.com/byuoitav/common/v2/events"
+)
+
+func TestApplyEvent(t *testing.T) {
+	now := time.Now()
+	target := events.GenerateBasicDeviceInfo("ITB-1101-D1")
+
+	base, err := EventToStaticDevice(events.Event{Timestamp: now, TargetDevice: target, Key: "power", Value: "on"})
+	if err != nil {
+		t.Fatalf("failed to translate event: %s", err)
+	}
+
+	if base.DeviceID != "ITB-1101-D1" || base.Room != "ITB-1101" || base.Power != "on" {
+		t.Fatalf("unexpected device: %+v", base)
+	}
+
+	update := StaticDevice{}
+	for _, e := range []events.Event{
+		{Key: "volume", Value: "30"},
+		{Key: "mute", Value: "true"},
+		{Key: "battery-charge-hours-minutes", Value: "1:30"},
+		{Key: "cpu-usage-percent", Value: "12.5%"},
+	} {
+		e.Timestamp = now.Add(time.Second)
+		e.TargetDevice = target
+
+		if err := ApplyEvent(&update, e); err != nil {
+			t.Fatalf("failed to apply %v: %s", e.Key, err)
+		}
+	}
+
+	if *update.Volume != 30 || !*update.Muted || *update.CPUUsagePercentage != 12.5 {
+		t.Fatalf("unexpected device: %+v", update)
+	}
+
+	if _, ok := update.UpdateTimes["battery-chage-hours-minutes"]; !ok {
+		t.Fatalf("expected the update time to use the key CompareDevices uses, got %v", update.UpdateTimes)
+	}
+
+	diff, merged, changes, nerr := CompareDevices(base, update)
+	if nerr != nil || !changes || *diff.Volume != 30 || merged.Power != "on" || merged.BatteryChargeHoursMinutes != "1:30" {
+		t.Fatalf("expected the update to be merged, got %+v (err: %v)", merged, nerr)
+	}
+
+	err = ApplyEvent(&update, events.Event{Key: "color", Value: "blue", TargetDevice: target})
+	if _, ok := err.(*UnknownKeyError); !ok {
+		t.Fatalf("expected an unknown key error, got %v", err)
+	}
+
+	if err := ApplyEvent(&update, events.Event{Key: "volume", Value: "loud", TargetDevice: target}); err == nil {
+		t.Fatalf("expected an error for an invalid volume")
+	}
+}
@@ -0,0 +1,166 @@
+package events
+
+import (
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/byuoitav/common/log"
+)
+
+// Identity is the system generating events, and the room it's in.
+type Identity struct {
+	GeneratingSystem string        `json:"generating-system"`
+	Room             BasicRoomInfo `json:"room"`
+}
+
+var (
+	defaultIdentity Identity
+	identityOnce    sync.Once
+)
+
+// DefaultIdentity returns the identity from ResolveIdentity, which is only resolved the first time it's called.
+func DefaultIdentity() Identity {
+	identityOnce.Do(func() {
+		defaultIdentity = ResolveIdentity()
+	})
+
+	return defaultIdentity
+}
+
+// ResolveIdentity figures out which system this is from the SYSTEM_ID environment variable, falling back to the hostname if it isn't set.
+// If the system's ID is in the form BLDG-ROOM-DEVICE, the room is filled in from it; otherwise the room is left empty.
+func ResolveIdentity() Identity {
+	system := strings.TrimSpace(os.Getenv("SYSTEM_ID"))
+	if len(system) == 0 {
+		hostname, err := os.Hostname()
+		if err != nil {
+			log.L.Warnf("[events] SYSTEM_ID isn't set, and unable to get hostname: %s", err)
+			hostname = "unknown"
+		}
+
+		system = hostname
+	}
+
+	id := Identity{GeneratingSystem: system}
+
+	if info := GenerateBasicDeviceInfo(system); len(info.RoomID) > 0 {
+		id.Room = info.BasicRoomInfo
+	} else {
+		log.L.Debugf("[events] Unable to get a room from system ID %q", system)
+	}
+
+	return id
+}
+
+// Builder builds events from a system's identity. Each setter returns a new Builder, so a partly built Builder can be reused as a template:
+//
+//	b := events.NewBuilder().Tags(events.CoreState)
+//	e, err := b.Target("ITB-1101-D1").KeyValue("power", "on").Build()
+type Builder struct {
+	identity  Identity
+	validator Validator
+	event     Event
+	room      bool
+}
+
+// NewBuilder creates a builder for events generated by this system. See DefaultIdentity.
+func NewBuilder() Builder {
+	return NewBuilderFor(DefaultIdentity())
+}
+
+// NewBuilderFor creates a builder for events generated by the system in id.
+func NewBuilderFor(id Identity) Builder {
+	v := DefaultValidator
+	v.GeneratingSystem = id.GeneratingSystem
+
+	return Builder{
+		identity:  id,
+		validator: v,
+	}
+}
+
+// Target sets the device the event affects. Unless Room is used, the affected room is the device's room, or the system's room if the device ID doesn't include one.
+func (b Builder) Target(deviceID string) Builder {
+	b.event.TargetDevice = GenerateBasicDeviceInfo(deviceID)
+	return b
+}
+
+// Room sets the room the event affects.
+func (b Builder) Room(roomID string) Builder {
+	b.event.AffectedRoom = GenerateBasicRoomInfo(roomID)
+	b.room = true
+	return b
+}
+
+// Key sets the event's key.
+func (b Builder) Key(key string) Builder {
+	b.event.Key = key
+	return b
+}
+
+// Value sets the event's value.
+func (b Builder) Value(value string) Builder {
+	b.event.Value = value
+	return b
+}
+
+// KeyValue sets the event's key and value.
+func (b Builder) KeyValue(key, value string) Builder {
+	return b.Key(key).Value(value)
+}
+
+// Tags adds tags to the event.
+func (b Builder) Tags(tags ...string) Builder {
+	b.event.EventTags = append(append([]string{}, b.event.EventTags...), tags...)
+	return b
+}
+
+// User sets the user that generated the event.
+func (b Builder) User(user string) Builder {
+	b.event.User = user
+	return b
+}
+
+// Data sets the event's data.
+func (b Builder) Data(data interface{}) Builder {
+	b.event.Data = data
+	return b
+}
+
+// Timestamp sets when the event happened. If it isn't set, it's the time Build is called.
+func (b Builder) Timestamp(t time.Time) Builder {
+	b.event.Timestamp = t
+	return b
+}
+
+// Validator sets the validator Build uses.
+func (b Builder) Validator(v Validator) Builder {
+	if len(v.GeneratingSystem) == 0 {
+		v.GeneratingSystem = b.identity.GeneratingSystem
+	}
+
+	b.validator = v
+	return b
+}
+
+// Build fills in the generating system, timestamp, and affected room, and then normalizes and validates the event. If it's invalid, the event is returned along with a *ValidationError.
+func (b Builder) Build() (Event, error) {
+	e := b.event
+	e.EventTags = append([]string{}, e.EventTags...)
+	e.GeneratingSystem = b.identity.GeneratingSystem
+
+	if e.Timestamp.IsZero() {
+		e.Timestamp = time.Now()
+	}
+
+	if !b.room {
+		e.AffectedRoom = e.TargetDevice.BasicRoomInfo
+		if len(e.AffectedRoom.RoomID) == 0 {
+			e.AffectedRoom = b.identity.Room
+		}
+	}
+
+	return b.validator.Normalize(e)
+}
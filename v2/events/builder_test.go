@@ -0,0 +1,47 @@
+package events
+
+import (
+	"os"
+	"testing"
+)
+
+func TestResolveIdentity(t *testing.T) {
+	defer os.Setenv("SYSTEM_ID", os.Getenv("SYSTEM_ID"))
+
+	os.Setenv("SYSTEM_ID", "ITB-1101-CP1")
+	if id := ResolveIdentity(); id.GeneratingSystem != "ITB-1101-CP1" || id.Room.RoomID != "ITB-1101" {
+		t.Fatalf("unexpected identity: %+v", id)
+	}
+
+	// health.BuildEvent used to panic on this
+	os.Setenv("SYSTEM_ID", "aws")
+	if id := ResolveIdentity(); id.GeneratingSystem != "aws" || len(id.Room.RoomID) > 0 {
+		t.Fatalf("unexpected identity: %+v", id)
+	}
+}
+
+func TestBuilder(t *testing.T) {
+	b := NewBuilderFor(Identity{GeneratingSystem: "ITB-1101-CP1", Room: GenerateBasicRoomInfo("ITB-1101")}).Tags(CoreState)
+
+	power, err := b.Target("itb-1108-d1").KeyValue("power", "on").Build()
+	if err != nil {
+		t.Fatalf("failed to build event: %s", err)
+	}
+
+	if power.AffectedRoom.RoomID != "ITB-1108" || power.TargetDevice.DeviceID != "ITB-1108-D1" || power.GeneratingSystem != "ITB-1101-CP1" || power.Timestamp.IsZero() {
+		t.Fatalf("unexpected event: %+v", power)
+	}
+
+	startup, err := b.Tags(StartUp).Target("av-api").KeyValue("ready", "true").Build()
+	if err != nil {
+		t.Fatalf("failed to build event: %s", err)
+	}
+
+	if startup.AffectedRoom.RoomID != "ITB-1101" || len(startup.EventTags) != 2 || len(power.EventTags) != 1 {
+		t.Fatalf("unexpected event: %+v", startup)
+	}
+
+	if _, err := b.Target("ITB-1108-D1").Room("ITB-1101").Build(); err == nil {
+		t.Fatalf("expected an event without a key in the wrong room to be invalid")
+	}
+}
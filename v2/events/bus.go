@@ -0,0 +1,269 @@
+package events
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/byuoitav/common/log"
+)
+
+// DefaultBufferSize is how many events a subscription holds before its policy kicks in, unless WithBuffer is used.
+const DefaultBufferSize = 100
+
+// Policy decides what happens when an event is published to a subscription whose buffer is full.
+type Policy int
+
+const (
+	// DropNewest drops the event being published. It is the default
+	DropNewest Policy = iota
+
+	// DropOldest drops the oldest event in the buffer to make room for the new one. A subscription without a buffer has nothing to drop, so it drops the new event instead
+	DropOldest
+
+	// Block makes Publish wait until the subscriber has room
+	Block
+)
+
+// Filter decides which events a subscription gets. Each field that is set has to match; a Filter with nothing set matches every event.
+// Room, building, and device IDs are compared without case.
+type Filter struct {
+	// AllTags matches events that have every one of these tags
+	AllTags []string `json:"all-tags,omitempty"`
+
+	// AnyTags matches events that have at least one of these tags
+	AnyTags []string `json:"any-tags,omitempty"`
+
+	// Keys matches events whose key is one of these
+	Keys []string `json:"keys,omitempty"`
+
+	// RoomIDs matches events whose affected room is one of these
+	RoomIDs []string `json:"room-ids,omitempty"`
+
+	// BuildingIDs matches events whose affected room is in one of these buildings
+	BuildingIDs []string `json:"building-ids,omitempty"`
+
+	// DeviceIDs matches events whose target device is one of these
+	DeviceIDs []string `json:"device-ids,omitempty"`
+}
+
+// Matches returns true if e passes the filter.
+func (f Filter) Matches(e Event) bool {
+	switch {
+	case len(f.AllTags) > 0 && !ContainsAllTags(e, f.AllTags...):
+		return false
+	case len(f.AnyTags) > 0 && !ContainsAnyTags(e, f.AnyTags...):
+		return false
+	case len(f.Keys) > 0 && !contains(f.Keys, e.Key, false):
+		return false
+	case len(f.RoomIDs) > 0 && !contains(f.RoomIDs, e.AffectedRoom.RoomID, true):
+		return false
+	case len(f.BuildingIDs) > 0 && !contains(f.BuildingIDs, e.AffectedRoom.BuildingID, true):
+		return false
+	case len(f.DeviceIDs) > 0 && !contains(f.DeviceIDs, e.TargetDevice.DeviceID, true):
+		return false
+	}
+
+	return true
+}
+
+func contains(list []string, s string, fold bool) bool {
+	for _, item := range list {
+		if item == s || (fold && strings.EqualFold(item, s)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Bus passes events published in a process on to each subscription whose filter they match.
+// Bus.Publish can be passed anywhere a func(Event) is expected, e.g. health.SendSuccessfulStartup.
+type Bus struct {
+	subs   map[*Subscription]struct{}
+	mu     sync.RWMutex
+	closed bool
+}
+
+// NewBus creates an empty bus.
+func NewBus() *Bus {
+	return &Bus{
+		subs: make(map[*Subscription]struct{}),
+	}
+}
+
+// Publish sends e to every matching subscription. It only blocks if a matching subscription uses the Block policy and its buffer is full.
+func (b *Bus) Publish(e Event) {
+	b.mu.RLock()
+	subs := make([]*Subscription, 0, len(b.subs))
+	for sub := range b.subs {
+		if sub.filter.Matches(e) {
+			subs = append(subs, sub)
+		}
+	}
+	b.mu.RUnlock()
+
+	for _, sub := range subs {
+		sub.send(e)
+	}
+}
+
+// SubscribeOption configures a subscription.
+type SubscribeOption func(*Subscription)
+
+// WithBuffer sets how many events the subscription holds before its policy kicks in.
+func WithBuffer(size int) SubscribeOption {
+	return func(s *Subscription) {
+		if size < 0 {
+			size = 0
+		}
+
+		s.size = size
+	}
+}
+
+// WithPolicy sets what happens when the subscription's buffer is full.
+func WithPolicy(p Policy) SubscribeOption {
+	return func(s *Subscription) {
+		s.policy = p
+	}
+}
+
+// Subscribe creates a subscription to every event that matches filter. If the bus is closed, the subscription's channel is already closed.
+func (b *Bus) Subscribe(filter Filter, opts ...SubscribeOption) *Subscription {
+	sub := &Subscription{
+		bus:    b,
+		filter: filter,
+		size:   DefaultBufferSize,
+		done:   make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(sub)
+	}
+
+	sub.c = make(chan Event, sub.size)
+	sub.C = sub.c
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		sub.close()
+		return sub
+	}
+
+	b.subs[sub] = struct{}{}
+	return sub
+}
+
+// Close closes every subscription, and drops events published afterwards.
+func (b *Bus) Close() {
+	b.mu.Lock()
+	b.closed = true
+
+	subs := b.subs
+	b.subs = make(map[*Subscription]struct{})
+	b.mu.Unlock()
+
+	for sub := range subs {
+		sub.close()
+	}
+}
+
+// Subscription receives the events published to a bus that match its filter.
+type Subscription struct {
+	// C receives the events. It is closed when the subscription is closed
+	C <-chan Event
+
+	bus    *Bus
+	filter Filter
+	size   int
+	policy Policy
+
+	c       chan Event
+	done    chan struct{}
+	dropped uint64
+
+	// mu protects sending on c, so that it isn't closed during a send
+	mu     sync.Mutex
+	closed bool
+	once   sync.Once
+}
+
+// Dropped returns how many events have been dropped because the buffer was full.
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Close stops the subscription and closes C.
+func (s *Subscription) Close() {
+	s.bus.mu.Lock()
+	delete(s.bus.subs, s)
+	s.bus.mu.Unlock()
+
+	s.close()
+}
+
+func (s *Subscription) close() {
+	s.once.Do(func() {
+		// unblock a publisher waiting on us
+		close(s.done)
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		s.closed = true
+		close(s.c)
+	})
+}
+
+func (s *Subscription) send(e Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return
+	}
+
+	select {
+	case s.c <- e:
+		return
+	default:
+	}
+
+	switch s.policy {
+	case Block:
+		select {
+		case s.c <- e:
+		case <-s.done:
+		}
+	case DropOldest:
+		if cap(s.c) == 0 {
+			s.drop(e)
+			return
+		}
+
+		for {
+			select {
+			case s.c <- e:
+				return
+			default:
+			}
+
+			select {
+			case old := <-s.c:
+				s.drop(old)
+			default:
+			}
+		}
+	default:
+		s.drop(e)
+	}
+}
+
+func (s *Subscription) drop(e Event) {
+	if atomic.AddUint64(&s.dropped, 1) == 1 {
+		log.L.Warnf("[events] Subscription buffer is full, dropping events (first dropped event: %v)", e.Key)
+	}
+}
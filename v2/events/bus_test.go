@@ -0,0 +1,99 @@
+package events
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBusFilters(t *testing.T) {
+	bus := NewBus()
+	defer bus.Close()
+
+	sub := bus.Subscribe(Filter{
+		AnyTags: []string{CoreState, DetailState},
+		RoomIDs: []string{"itb-1101"},
+	})
+
+	bus.Publish(Event{Key: "power", EventTags: []string{CoreState}, AffectedRoom: GenerateBasicRoomInfo("ITB-1101")})
+	bus.Publish(Event{Key: "power", EventTags: []string{Heartbeat}, AffectedRoom: GenerateBasicRoomInfo("ITB-1101")})
+	bus.Publish(Event{Key: "input", EventTags: []string{DetailState}, AffectedRoom: GenerateBasicRoomInfo("ITB-1108")})
+
+	select {
+	case e := <-sub.C:
+		if e.Key != "power" {
+			t.Fatalf("got the wrong event: %+v", e)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("never got the matching event")
+	}
+
+	select {
+	case e := <-sub.C:
+		t.Fatalf("got an event that doesn't match: %+v", e)
+	default:
+	}
+}
+
+func TestBusPolicies(t *testing.T) {
+	bus := NewBus()
+
+	newest := bus.Subscribe(Filter{}, WithBuffer(2))
+	oldest := bus.Subscribe(Filter{}, WithBuffer(2), WithPolicy(DropOldest))
+	blocking := bus.Subscribe(Filter{}, WithBuffer(2), WithPolicy(Block))
+
+	published := make(chan struct{})
+	go func() {
+		for _, key := range []string{"1", "2", "3"} {
+			bus.Publish(Event{Key: key})
+		}
+
+		close(published)
+	}()
+
+	select {
+	case <-published:
+		t.Fatalf("publish should block until the blocking subscription has room")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if e := <-blocking.C; e.Key != "1" {
+		t.Fatalf("expected the first event, got %+v", e)
+	}
+	<-published
+
+	if e := <-newest.C; e.Key != "1" || newest.Dropped() != 1 {
+		t.Fatalf("expected the newest event to be dropped, got %+v (%v dropped)", e, newest.Dropped())
+	}
+
+	if e := <-oldest.C; e.Key != "2" || oldest.Dropped() != 1 {
+		t.Fatalf("expected the oldest event to be dropped, got %+v (%v dropped)", e, oldest.Dropped())
+	}
+
+	bus.Close()
+	for range blocking.C {
+	}
+}
+
+func TestBusDropOldestUnbuffered(t *testing.T) {
+	bus := NewBus()
+	defer bus.Close()
+
+	sub := bus.Subscribe(Filter{}, WithBuffer(0), WithPolicy(DropOldest))
+
+	// nobody is receiving, so there's nowhere to put the event
+	published := make(chan struct{})
+	go func() {
+		bus.Publish(Event{Key: "power"})
+		close(published)
+	}()
+
+	select {
+	case <-published:
+	case <-time.After(time.Second):
+		t.Fatalf("publish should drop the event instead of waiting for room")
+	}
+
+	if sub.Dropped() != 1 {
+		t.Fatalf("expected the event to be dropped, got %v dropped", sub.Dropped())
+	}
+}
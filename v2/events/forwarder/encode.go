@@ -0,0 +1,49 @@
+package forwarder
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/byuoitav/common/v2/events"
+)
+
+// Encoder turns a batch of events into the body of a request, and returns the body's content type.
+type Encoder func(batch []events.Event) ([]byte, string, error)
+
+// JSONEncoder encodes a batch as a JSON array of events, which is what the event translator expects.
+func JSONEncoder(batch []events.Event) ([]byte, string, error) {
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to marshal events: %s", err)
+	}
+
+	return body, "application/json", nil
+}
+
+// BulkEncoder returns an encoder for an Elasticsearch bulk API, which indexes each event into index.
+func BulkEncoder(index string) Encoder {
+	action, _ := json.Marshal(map[string]interface{}{
+		"index": map[string]string{
+			"_index": index,
+		},
+	})
+
+	return func(batch []events.Event) ([]byte, string, error) {
+		var buf bytes.Buffer
+
+		for _, e := range batch {
+			doc, err := json.Marshal(e)
+			if err != nil {
+				return nil, "", fmt.Errorf("unable to marshal event %v: %s", e.Key, err)
+			}
+
+			buf.Write(action)
+			buf.WriteByte('\n')
+			buf.Write(doc)
+			buf.WriteByte('\n')
+		}
+
+		return buf.Bytes(), "application/x-ndjson", nil
+	}
+}
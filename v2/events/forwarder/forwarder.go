@@ -0,0 +1,634 @@
+// Package forwarder sends v2 events to an HTTP collector, like the event translator or an Elasticsearch bulk API.
+//
+// Events are queued in memory and posted in batches. A batch that fails is retried with backoff until it's sent, so events published in the meantime wait in the queue; once the queue is full, they're spilled to disk (if a spill directory is set) and forwarded after everything ahead of them has been sent. The exception is a Close that gives up while events are spilled: what was still queued in memory is spilled behind them, so it's sent after events that were published later.
+package forwarder
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/byuoitav/common/log"
+	"github.com/byuoitav/common/v2/events"
+	"github.com/byuoitav/common/v2/events/store"
+)
+
+// Defaults for a Forwarder, unless they're changed with an Option
+const (
+	DefaultBatchSize        = 100
+	DefaultFlushInterval    = 5 * time.Second
+	DefaultQueueSize        = 1000
+	DefaultMinBackoff       = time.Second
+	DefaultMaxBackoff       = time.Minute
+	DefaultSpillSegmentSize = 4 * 1024 * 1024
+)
+
+// offsetFile is where the offset of the last spilled event that was sent is saved, in the spill directory
+const offsetFile = "forwarded"
+
+// Option configures a Forwarder.
+type Option func(*Forwarder)
+
+// WithBatchSize sets the most events that are sent in one request. If n <= 0, DefaultBatchSize is used.
+func WithBatchSize(n int) Option {
+	return func(f *Forwarder) {
+		f.batchSize = n
+	}
+}
+
+// WithFlushInterval sets how long to wait for a batch to fill up before sending what's there. If interval <= 0, DefaultFlushInterval is used.
+func WithFlushInterval(interval time.Duration) Option {
+	return func(f *Forwarder) {
+		f.flushInterval = interval
+	}
+}
+
+// WithQueueSize sets how many events can be waiting in memory to be sent. If n <= 0, DefaultQueueSize is used.
+func WithQueueSize(n int) Option {
+	return func(f *Forwarder) {
+		f.queueSize = n
+	}
+}
+
+// WithEncoder sets how batches are encoded. The default is JSONEncoder.
+func WithEncoder(encode Encoder) Option {
+	return func(f *Forwarder) {
+		f.encode = encode
+	}
+}
+
+// WithClient sets the http client used to send batches.
+func WithClient(client *http.Client) Option {
+	return func(f *Forwarder) {
+		f.client = client
+	}
+}
+
+// WithHeader adds a header to every request, e.g. for authorization.
+func WithHeader(key, value string) Option {
+	return func(f *Forwarder) {
+		f.header.Add(key, value)
+	}
+}
+
+// WithBackoff sets how long to wait before retrying a failed batch. The wait doubles after each failure, up to max. If min <= 0, DefaultMinBackoff is used, and if max is less than min, it's the same as min.
+func WithBackoff(min, max time.Duration) Option {
+	return func(f *Forwarder) {
+		f.minBackoff = min
+		f.maxBackoff = max
+	}
+}
+
+// WithSpillDir stores events in dir when the queue is full, instead of dropping them. Events left in dir when the forwarder is closed are sent the next time a forwarder is created with it.
+func WithSpillDir(dir string) Option {
+	return func(f *Forwarder) {
+		f.spillDir = dir
+	}
+}
+
+// Stats is how many events a Forwarder has handled, and how many are waiting to be sent.
+type Stats struct {
+	// Queued is how many events are waiting in memory
+	Queued int `json:"queued"`
+
+	// Spilled is how many events are waiting on disk
+	Spilled uint64 `json:"spilled"`
+
+	Sent    uint64 `json:"sent"`
+	Batches uint64 `json:"batches"`
+	Retries uint64 `json:"retries"`
+
+	// Rejected is how many events the collector refused, which aren't retried
+	Rejected uint64 `json:"rejected"`
+
+	// Dropped is how many events were dropped without being sent, e.g. because the queue was full and there was nowhere to spill them
+	Dropped uint64 `json:"dropped"`
+
+	LastSent  time.Time `json:"last-sent,omitempty"`
+	LastError string    `json:"last-error,omitempty"`
+}
+
+// StatusError is returned when the collector responds with a non-2xx status.
+type StatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("collector responded with %v: %s", e.StatusCode, e.Body)
+}
+
+// Temporary returns true if the batch might be accepted if it's sent again. Only responses saying that the batch itself is bad (400, 413, and 422) aren't temporary, so that a collector that is misconfigured or down doesn't lose events.
+func (e *StatusError) Temporary() bool {
+	switch e.StatusCode {
+	case http.StatusBadRequest, http.StatusRequestEntityTooLarge, http.StatusUnprocessableEntity:
+		return false
+	}
+
+	return true
+}
+
+// Forwarder batches events and posts them to a collector.
+type Forwarder struct {
+	url           string
+	client        *http.Client
+	header        http.Header
+	encode        Encoder
+	batchSize     int
+	flushInterval time.Duration
+	queueSize     int
+	minBackoff    time.Duration
+	maxBackoff    time.Duration
+	spillDir      string
+
+	queue chan events.Event
+	spill *store.Store
+
+	// ctx is canceled if closing takes too long, to stop retrying
+	ctx    context.Context
+	cancel context.CancelFunc
+	stop   chan struct{}
+	done   chan struct{}
+
+	mu     sync.Mutex
+	closed bool
+	full   bool
+
+	// spilling is true while there are events on disk, so that new events go behind them
+	spilling bool
+
+	// forwarded is the offset of the last spilled event that was sent
+	forwarded uint64
+	stats     Stats
+}
+
+// New creates a forwarder that posts events to url, and starts sending them.
+func New(url string, opts ...Option) (*Forwarder, error) {
+	f := &Forwarder{
+		url:           url,
+		client:        &http.Client{Timeout: 30 * time.Second},
+		header:        make(http.Header),
+		encode:        JSONEncoder,
+		batchSize:     DefaultBatchSize,
+		flushInterval: DefaultFlushInterval,
+		queueSize:     DefaultQueueSize,
+		minBackoff:    DefaultMinBackoff,
+		maxBackoff:    DefaultMaxBackoff,
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(f)
+	}
+
+	// a zero flush interval would panic, and a zero backoff would retry as fast as it can
+	if f.batchSize <= 0 {
+		f.batchSize = DefaultBatchSize
+	}
+
+	if f.flushInterval <= 0 {
+		f.flushInterval = DefaultFlushInterval
+	}
+
+	if f.queueSize <= 0 {
+		f.queueSize = DefaultQueueSize
+	}
+
+	if f.minBackoff <= 0 {
+		f.minBackoff = DefaultMinBackoff
+	}
+
+	if f.maxBackoff < f.minBackoff {
+		f.maxBackoff = f.minBackoff
+	}
+
+	if len(f.spillDir) > 0 {
+		spill, err := store.Open(f.spillDir, store.WithMaxSegmentSize(DefaultSpillSegmentSize))
+		if err != nil {
+			return nil, fmt.Errorf("unable to open spill directory: %s", err)
+		}
+
+		forwarded, err := loadOffset(f.spillDir)
+		if err != nil {
+			spill.Close()
+			return nil, err
+		}
+
+		if last := spill.Last(); forwarded > last {
+			forwarded = last
+		}
+
+		f.spill = spill
+		f.forwarded = forwarded
+		f.spilling = forwarded < spill.Last()
+
+		if f.spilling {
+			log.L.Infof("[forwarder] %v events to %v were left in %v", spill.Last()-forwarded, url, f.spillDir)
+		}
+	}
+
+	f.queue = make(chan events.Event, f.queueSize)
+	f.ctx, f.cancel = context.WithCancel(context.Background())
+
+	go f.run()
+	return f, nil
+}
+
+// Publish queues e to be sent. It never blocks; if the queue is full, e is spilled to disk, or dropped if there isn't a spill directory. It can be used anywhere a func(events.Event) is expected, e.g. with a bus subscription or a limiter.
+func (f *Forwarder) Publish(e events.Event) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		f.stats.Dropped++
+		log.L.Debugf("[forwarder] Dropping event %v: forwarder is closed", e.Key)
+		return
+	}
+
+	if !f.spilling {
+		select {
+		case f.queue <- e:
+			f.full = false
+			return
+		default:
+		}
+
+		if f.spill == nil {
+			if !f.full {
+				log.L.Warnf("[forwarder] Queue to %v is full, dropping events", f.url)
+				f.full = true
+			}
+
+			f.stats.Dropped++
+			return
+		}
+
+		log.L.Warnf("[forwarder] Queue to %v is full, spilling events to %v", f.url, f.spillDir)
+		f.spilling = true
+	}
+
+	if _, err := f.spill.Append(e); err != nil {
+		log.L.Warnf("[forwarder] Dropping event %v: unable to spill it: %s", e.Key, err)
+		f.stats.Dropped++
+	}
+}
+
+// Stats returns how many events have been handled, and how many are waiting to be sent.
+func (f *Forwarder) Stats() Stats {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	stats := f.stats
+	stats.Queued = len(f.queue)
+
+	if f.spill != nil {
+		stats.Spilled = f.spill.Last() - f.forwarded
+	}
+
+	return stats
+}
+
+// Close stops accepting events, and sends the ones that are still queued in memory. Spilled events stay on disk.
+// If ctx is done before that finishes, the events that weren't sent are spilled (or dropped if there isn't a spill directory) and ctx's error is returned. If events were already spilled, the ones from memory go after them, so they'll be sent out of order.
+func (f *Forwarder) Close(ctx context.Context) error {
+	f.mu.Lock()
+	if f.closed {
+		f.mu.Unlock()
+		return nil
+	}
+
+	f.closed = true
+	f.mu.Unlock()
+
+	close(f.stop)
+
+	var err error
+	select {
+	case <-f.done:
+	case <-ctx.Done():
+		f.cancel()
+		<-f.done
+		err = ctx.Err()
+	}
+
+	f.cancel()
+
+	if f.spill != nil {
+		if serr := f.spill.Close(); serr != nil && err == nil {
+			err = fmt.Errorf("unable to close spill directory: %s", serr)
+		}
+	}
+
+	return err
+}
+
+func (f *Forwarder) run() {
+	defer close(f.done)
+
+	ticker := time.NewTicker(f.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]events.Event, 0, f.batchSize)
+
+	for {
+		select {
+		case e := <-f.queue:
+			batch = append(batch, e)
+			if len(batch) < f.batchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				// spilled events are behind everything in the queue
+				if len(f.queue) == 0 {
+					f.drainSpill()
+				}
+
+				continue
+			}
+		case <-f.stop:
+			f.flush(batch)
+			return
+		}
+
+		if !f.send(batch, nil) {
+			f.abandon(batch)
+			return
+		}
+
+		batch = batch[:0]
+	}
+}
+
+// flush sends everything left in the queue
+func (f *Forwarder) flush(batch []events.Event) {
+	for {
+	fill:
+		for len(batch) < f.batchSize {
+			select {
+			case e := <-f.queue:
+				batch = append(batch, e)
+			default:
+				break fill
+			}
+		}
+
+		if len(batch) == 0 {
+			return
+		}
+
+		if !f.send(batch, nil) {
+			f.abandon(batch)
+			return
+		}
+
+		batch = batch[:0]
+	}
+}
+
+// abandon spills batch and everything left in the queue, once sending has been given up on. The spill is append only, so if it already has events, these end up behind them even though they were published first.
+func (f *Forwarder) abandon(batch []events.Event) {
+drain:
+	for {
+		select {
+		case e := <-f.queue:
+			batch = append(batch, e)
+		default:
+			break drain
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.spill == nil {
+		log.L.Warnf("[forwarder] Dropping %v events that weren't sent to %v", len(batch), f.url)
+		f.stats.Dropped += uint64(len(batch))
+		return
+	}
+
+	for _, e := range batch {
+		if _, err := f.spill.Append(e); err != nil {
+			log.L.Warnf("[forwarder] Dropping event %v: unable to spill it: %s", e.Key, err)
+			f.stats.Dropped++
+		}
+	}
+}
+
+// drainSpill sends the events on disk, until it's caught up or the forwarder is closed
+func (f *Forwarder) drainSpill() {
+	if f.spill == nil {
+		return
+	}
+
+	for {
+		select {
+		case <-f.stop:
+			return
+		default:
+		}
+
+		f.mu.Lock()
+		after, end := f.forwarded, f.spill.Last()
+		if after >= end {
+			if f.spilling {
+				f.spilling = false
+				f.trimSpill()
+				log.L.Infof("[forwarder] Sent every spilled event to %v", f.url)
+			}
+
+			f.mu.Unlock()
+			return
+		}
+		f.mu.Unlock()
+
+		var batch []events.Event
+		var first, last uint64
+
+		err := f.spill.Replay(store.Query{After: after}, func(r store.Record) error {
+			if len(batch) == 0 {
+				first = r.Offset
+			}
+
+			batch = append(batch, r.Event)
+			last = r.Offset
+
+			if len(batch) >= f.batchSize {
+				return store.ErrStop
+			}
+
+			return nil
+		})
+		if err != nil {
+			log.L.Warnf("[forwarder] Unable to read spilled events: %s", err)
+			return
+		}
+
+		if len(batch) > 0 && !f.send(batch, f.stop) {
+			return
+		}
+
+		// spilled events that were deleted before they were sent are skipped
+		if len(batch) == 0 {
+			first, last = end+1, end
+		}
+
+		if lost := first - after - 1; lost > 0 {
+			log.L.Warnf("[forwarder] Lost %v spilled events that were deleted before they were sent to %v", lost, f.url)
+			f.record(func(s *Stats) {
+				s.Dropped += lost
+			})
+		}
+
+		f.mu.Lock()
+		f.forwarded = last
+		f.mu.Unlock()
+
+		if err := saveOffset(f.spillDir, last); err != nil {
+			log.L.Warnf("[forwarder] Unable to save spill offset: %s", err)
+		}
+	}
+}
+
+// trimSpill deletes the spilled segments that have all been sent. The caller must hold mu, so that nothing is spilled into a segment while it's deleted
+func (f *Forwarder) trimSpill() {
+	if _, err := f.spill.DeleteBefore(time.Now()); err != nil {
+		log.L.Warnf("[forwarder] Unable to delete spilled events that were sent: %s", err)
+	}
+}
+
+// send posts batch, retrying until it's sent. It returns false if the forwarder gave up on it because closing took too long, or because stop was closed
+func (f *Forwarder) send(batch []events.Event, stop <-chan struct{}) bool {
+	body, contentType, err := f.encode(batch)
+	if err != nil {
+		log.L.Warnf("[forwarder] Dropping %v events that couldn't be encoded: %s", len(batch), err)
+		f.record(func(s *Stats) {
+			s.Rejected += uint64(len(batch))
+			s.LastError = err.Error()
+		})
+
+		return true
+	}
+
+	backoff := f.minBackoff
+
+	for {
+		err := f.post(body, contentType)
+		if err == nil {
+			log.L.Debugf("[forwarder] Sent %v events to %v", len(batch), f.url)
+			f.record(func(s *Stats) {
+				s.Sent += uint64(len(batch))
+				s.Batches++
+				s.LastSent = time.Now()
+			})
+
+			return true
+		}
+
+		if serr, ok := err.(*StatusError); ok && !serr.Temporary() {
+			log.L.Warnf("[forwarder] %v rejected %v events: %s", f.url, len(batch), err)
+			f.record(func(s *Stats) {
+				s.Rejected += uint64(len(batch))
+				s.LastError = err.Error()
+			})
+
+			return true
+		}
+
+		log.L.Warnf("[forwarder] Failed to send %v events to %v, retrying in %v: %s", len(batch), f.url, backoff, err)
+		f.record(func(s *Stats) {
+			s.Retries++
+			s.LastError = err.Error()
+		})
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-f.ctx.Done():
+			timer.Stop()
+			return false
+		case <-stop:
+			timer.Stop()
+			return false
+		}
+
+		backoff *= 2
+		if backoff > f.maxBackoff {
+			backoff = f.maxBackoff
+		}
+	}
+}
+
+func (f *Forwarder) post(body []byte, contentType string) error {
+	req, err := http.NewRequest(http.MethodPost, f.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("unable to build request: %s", err)
+	}
+
+	req = req.WithContext(f.ctx)
+
+	for key, vals := range f.header {
+		req.Header[key] = vals
+	}
+
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return &StatusError{StatusCode: resp.StatusCode, Body: strings.TrimSpace(string(b))}
+	}
+
+	// read the rest of the body so the connection can be reused
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64*1024))
+	return nil
+}
+
+func (f *Forwarder) record(update func(*Stats)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	update(&f.stats)
+}
+
+func loadOffset(dir string) (uint64, error) {
+	b, err := ioutil.ReadFile(filepath.Join(dir, offsetFile))
+	switch {
+	case os.IsNotExist(err):
+		return 0, nil
+	case err != nil:
+		return 0, fmt.Errorf("unable to read spill offset: %s", err)
+	}
+
+	offset, err := strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid spill offset: %s", err)
+	}
+
+	return offset, nil
+}
+
+func saveOffset(dir string, offset uint64) error {
+	path := filepath.Join(dir, offsetFile)
+
+	if err := ioutil.WriteFile(path+".tmp", []byte(strconv.FormatUint(offset, 10)), 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(path+".tmp", path)
+}
@@ -0,0 +1,340 @@
+package forwarder
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/byuoitav/common/v2/events"
+	"github.com/byuoitav/common/v2/events/store"
+)
+
+func TestForwarderSpill(t *testing.T) {
+	dir, err := ioutil.TempDir("", "forwarder")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	var mu sync.Mutex
+	up := false
+	var received []events.Event
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if !up {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		var batch []events.Event
+		if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		received = append(received, batch...)
+	}))
+	defer srv.Close()
+
+	f, err := New(srv.URL, WithBatchSize(4), WithQueueSize(5), WithFlushInterval(10*time.Millisecond), WithBackoff(5*time.Millisecond, 20*time.Millisecond), WithSpillDir(dir))
+	if err != nil {
+		t.Fatalf("failed to create forwarder: %s", err)
+	}
+
+	// the first batch is stuck retrying, so the rest fill up the queue and spill
+	for i := 0; i < 20; i++ {
+		f.Publish(events.Event{Key: "input", Value: strconv.Itoa(i)})
+		time.Sleep(time.Millisecond)
+	}
+
+	stats := f.Stats()
+	if stats.Spilled == 0 || stats.Retries == 0 || stats.Dropped != 0 {
+		t.Fatalf("expected events to be spilled while the collector is down, got %+v", stats)
+	}
+
+	mu.Lock()
+	up = true
+	mu.Unlock()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		stats = f.Stats()
+		if stats.Sent == 20 && stats.Queued == 0 && stats.Spilled == 0 {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for events to be sent: %+v", stats)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := f.Close(context.Background()); err != nil {
+		t.Fatalf("failed to close forwarder: %s", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	for i, e := range received {
+		if e.Value != strconv.Itoa(i) {
+			t.Fatalf("expected events in order, got %v at %v", e.Value, i)
+		}
+	}
+
+	if offset, err := loadOffset(dir); err != nil || offset == 0 {
+		t.Fatalf("expected the spill offset to be saved, got %v (%v)", offset, err)
+	}
+}
+
+func TestForwarderClose(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	f, err := New(srv.URL, WithFlushInterval(time.Hour), WithBackoff(time.Millisecond, time.Millisecond))
+	if err != nil {
+		t.Fatalf("failed to create forwarder: %s", err)
+	}
+
+	f.Publish(events.Event{Key: "power", Value: "on"})
+	f.Publish(events.Event{Key: "power", Value: "standby"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if err := f.Close(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected close to time out, got %v", err)
+	}
+
+	f.Publish(events.Event{Key: "power", Value: "on"})
+
+	if stats := f.Stats(); stats.Dropped != 3 || stats.Sent != 0 {
+		t.Fatalf("expected every event to be dropped, got %+v", stats)
+	}
+}
+
+func TestForwarderCloseWhileDraining(t *testing.T) {
+	dir, err := ioutil.TempDir("", "forwarder")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	spill, err := store.Open(dir)
+	if err != nil {
+		t.Fatalf("failed to open spill: %s", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		spill.Append(events.Event{Key: "input", Value: strconv.Itoa(i)})
+	}
+	spill.Close()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	f, err := New(srv.URL, WithFlushInterval(5*time.Millisecond), WithBackoff(time.Hour, time.Hour), WithSpillDir(dir))
+	if err != nil {
+		t.Fatalf("failed to create forwarder: %s", err)
+	}
+
+	// wait for the spilled events to be retried
+	deadline := time.Now().Add(5 * time.Second)
+	for f.Stats().Retries == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for spilled events to be sent")
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// spilled events don't hold up closing, since they stay on disk
+	closed := make(chan error, 1)
+	go func() {
+		closed <- f.Close(context.Background())
+	}()
+
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Fatalf("failed to close forwarder: %s", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("close blocked on sending spilled events")
+	}
+
+	if offset, err := loadOffset(dir); err != nil || offset != 0 {
+		t.Fatalf("expected the spilled events to still need sending, got offset %v (%v)", offset, err)
+	}
+}
+
+func TestForwarderAbandonWhileSpilling(t *testing.T) {
+	dir, err := ioutil.TempDir("", "forwarder")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	f, err := New(srv.URL, WithBatchSize(2), WithQueueSize(2), WithBackoff(time.Hour, time.Hour), WithSpillDir(dir))
+	if err != nil {
+		t.Fatalf("failed to create forwarder: %s", err)
+	}
+
+	f.Publish(events.Event{Key: "input", Value: "0"})
+	f.Publish(events.Event{Key: "input", Value: "1"})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for f.Stats().Retries == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for the first batch to be retried")
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// the next two fill up the queue, and the last two are spilled
+	for i := 2; i < 6; i++ {
+		f.Publish(events.Event{Key: "input", Value: strconv.Itoa(i)})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if err := f.Close(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected close to time out, got %v", err)
+	}
+
+	spill, err := store.Open(dir)
+	if err != nil {
+		t.Fatalf("failed to open spill: %s", err)
+	}
+	defer spill.Close()
+
+	var order []string
+	spill.Replay(store.Query{}, func(r store.Record) error {
+		order = append(order, r.Event.Value)
+		return nil
+	})
+
+	// the events from memory are spilled behind the ones that were already on disk
+	if strings.Join(order, ",") != "4,5,0,1,2,3" {
+		t.Fatalf("expected the abandoned events after the spilled ones, got %v", order)
+	}
+}
+
+func TestForwarderLostSpill(t *testing.T) {
+	dir, err := ioutil.TempDir("", "forwarder")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// spill old events across a few segments, and then delete all but the last one
+	spill, err := store.Open(dir, store.WithMaxSegmentSize(256))
+	if err != nil {
+		t.Fatalf("failed to open spill: %s", err)
+	}
+
+	old := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 10; i++ {
+		spill.Append(events.Event{Timestamp: old, Key: "input", Value: strconv.Itoa(i)})
+	}
+
+	if deleted, err := spill.DeleteBefore(time.Now()); err != nil || deleted == 0 {
+		t.Fatalf("expected spilled segments to be deleted, got %v (%v)", deleted, err)
+	}
+
+	left := 0
+	spill.Replay(store.Query{}, func(store.Record) error {
+		left++
+		return nil
+	})
+	spill.Close()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	f, err := New(srv.URL, WithFlushInterval(5*time.Millisecond), WithSpillDir(dir))
+	if err != nil {
+		t.Fatalf("failed to create forwarder: %s", err)
+	}
+
+	// the deleted events are skipped, and the rest are sent
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		stats := f.Stats()
+		if stats.Spilled == 0 {
+			if stats.Sent != uint64(left) || stats.Dropped != uint64(10-left) {
+				t.Fatalf("expected %v events to be sent and %v to be lost, got %+v", left, 10-left, stats)
+			}
+
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for spilled events to be sent: %+v", stats)
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if err := f.Close(context.Background()); err != nil {
+		t.Fatalf("failed to close forwarder: %s", err)
+	}
+
+	if offset, err := loadOffset(dir); err != nil || offset != 10 {
+		t.Fatalf("expected the spill offset to be 10, got %v (%v)", offset, err)
+	}
+}
+
+func TestForwarderInvalidOptions(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	f, err := New(srv.URL, WithBatchSize(0), WithFlushInterval(0), WithQueueSize(-1), WithBackoff(0, 0))
+	if err != nil {
+		t.Fatalf("failed to create forwarder: %s", err)
+	}
+	defer f.Close(context.Background())
+
+	if f.batchSize != DefaultBatchSize || f.flushInterval != DefaultFlushInterval || f.queueSize != DefaultQueueSize {
+		t.Fatalf("expected the defaults to be used, got %v, %v, and %v", f.batchSize, f.flushInterval, f.queueSize)
+	}
+
+	if f.minBackoff != DefaultMinBackoff || f.maxBackoff != DefaultMinBackoff {
+		t.Fatalf("expected the backoff to be %v, got %v to %v", DefaultMinBackoff, f.minBackoff, f.maxBackoff)
+	}
+}
+
+func TestBulkEncoder(t *testing.T) {
+	body, contentType, err := BulkEncoder("events")([]events.Event{{Key: "power"}, {Key: "input"}})
+	if err != nil {
+		t.Fatalf("failed to encode: %s", err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(string(body), "\n"), "\n")
+	if contentType != "application/x-ndjson" || len(lines) != 4 || lines[0] != `{"index":{"_index":"events"}}` {
+		t.Fatalf("unexpected bulk body (%v):\n%s", contentType, body)
+	}
+}
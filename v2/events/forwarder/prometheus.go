@@ -0,0 +1,48 @@
+package forwarder
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Register registers prometheus metrics for the forwarder's stats with reg, named namespace_forwarder_* and labeled with the forwarder's url.
+func (f *Forwarder) Register(namespace string, reg prometheus.Registerer) error {
+	labels := prometheus.Labels{"url": f.url}
+
+	gauge := func(name, help string, value func(Stats) float64) prometheus.Collector {
+		return prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Subsystem:   "forwarder",
+			Name:        name,
+			Help:        help,
+			ConstLabels: labels,
+		}, func() float64 { return value(f.Stats()) })
+	}
+
+	counter := func(name, help string, value func(Stats) float64) prometheus.Collector {
+		return prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Subsystem:   "forwarder",
+			Name:        name,
+			Help:        help,
+			ConstLabels: labels,
+		}, func() float64 { return value(f.Stats()) })
+	}
+
+	collectors := []prometheus.Collector{
+		gauge("queued_events", "Number of events waiting in memory to be sent.", func(s Stats) float64 { return float64(s.Queued) }),
+		gauge("spilled_events", "Number of events waiting on disk to be sent.", func(s Stats) float64 { return float64(s.Spilled) }),
+		counter("sent_events_total", "Number of events sent.", func(s Stats) float64 { return float64(s.Sent) }),
+		counter("batches_total", "Number of batches sent.", func(s Stats) float64 { return float64(s.Batches) }),
+		counter("retries_total", "Number of times sending a batch failed and was retried.", func(s Stats) float64 { return float64(s.Retries) }),
+		counter("rejected_events_total", "Number of events the collector refused.", func(s Stats) float64 { return float64(s.Rejected) }),
+		counter("dropped_events_total", "Number of events dropped because the queue was full.", func(s Stats) float64 { return float64(s.Dropped) }),
+	}
+
+	for _, c := range collectors {
+		if err := reg.Register(c); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,186 @@
+package hub
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/byuoitav/common/log"
+	"github.com/byuoitav/common/v2/events"
+	"github.com/byuoitav/common/v2/events/rules"
+	"github.com/gorilla/websocket"
+)
+
+// matcher decides which events a client gets
+type matcher struct {
+	filter events.Filter
+	expr   *rules.Expr
+}
+
+func (m matcher) matches(e events.Event) bool {
+	return m.filter.Matches(e) && (m.expr == nil || m.expr.Matches(e))
+}
+
+type client struct {
+	hub  *Hub
+	conn *websocket.Conn
+	req  *http.Request
+	name string
+
+	send chan Message
+
+	// matcher is nil until the client subscribes. it's protected by the hub's mutex
+	matcher *matcher
+
+	done     chan struct{}
+	doneOnce sync.Once
+	reason   string
+}
+
+func newClient(h *Hub, conn *websocket.Conn, r *http.Request) *client {
+	return &client{
+		hub:  h,
+		conn: conn,
+		req:  r,
+		name: r.RemoteAddr,
+		send: make(chan Message, h.clientBuffer),
+		done: make(chan struct{}),
+	}
+}
+
+// setMatcher must be called with the hub's mutex held
+func (c *client) setMatcher(m matcher) {
+	c.matcher = &m
+}
+
+// deliver queues ent if it matches the client's filter. It must be called with the hub's mutex held
+func (c *client) deliver(ent entry) {
+	if c.matcher == nil || !c.matcher.matches(ent.event) {
+		return
+	}
+
+	e := ent.event
+	c.queue(Message{Type: TypeEvent, Seq: ent.seq, Event: &e})
+}
+
+// queue sends msg to the client, disconnecting it if it has fallen too far behind
+func (c *client) queue(msg Message) {
+	select {
+	case <-c.done:
+	case c.send <- msg:
+	default:
+		log.L.Warnf("[hub] Client %v has fallen too far behind, disconnecting it", c.name)
+		c.kick("too far behind")
+	}
+}
+
+// kick disconnects the client, telling it why
+func (c *client) kick(reason string) {
+	c.doneOnce.Do(func() {
+		c.reason = reason
+		close(c.done)
+	})
+}
+
+func (c *client) readPump() {
+	defer c.kick("")
+
+	c.conn.SetReadLimit(DefaultMaxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(c.hub.pongTimeout))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(c.hub.pongTimeout))
+	})
+
+	for {
+		var msg Message
+		if err := c.conn.ReadJSON(&msg); err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				log.L.Debugf("[hub] Failed to read from client %v: %s", c.name, err)
+			}
+
+			return
+		}
+
+		// any message counts as a heartbeat
+		c.conn.SetReadDeadline(time.Now().Add(c.hub.pongTimeout))
+
+		if err := c.handle(msg); err != nil {
+			c.queue(Message{Type: TypeError, Error: err.Error()})
+		}
+	}
+}
+
+func (c *client) handle(msg Message) error {
+	switch msg.Type {
+	case TypeSubscribe:
+		m := matcher{}
+		if msg.Filter != nil {
+			m.filter = *msg.Filter
+		}
+
+		if len(msg.Match) > 0 {
+			expr, err := rules.Compile(msg.Match)
+			if err != nil {
+				return fmt.Errorf("invalid match expression: %s", err)
+			}
+
+			m.expr = expr
+		}
+
+		c.hub.subscribe(c, msg, m)
+		return nil
+	case TypePublish:
+		if msg.Event == nil {
+			return fmt.Errorf("publish message is missing an event")
+		}
+
+		e := *msg.Event
+		e.AddToTags(events.UserGenerated)
+
+		if e.Timestamp.IsZero() {
+			e.Timestamp = time.Now()
+		}
+
+		if c.hub.publish != nil {
+			if err := c.hub.publish(c.req, &e); err != nil {
+				return fmt.Errorf("event was rejected: %s", err)
+			}
+		}
+
+		c.hub.bus.Publish(e)
+		return nil
+	default:
+		return fmt.Errorf("unknown message type %q", msg.Type)
+	}
+}
+
+func (c *client) writePump() {
+	ticker := time.NewTicker(c.hub.pingInterval)
+	defer ticker.Stop()
+	defer c.conn.Close()
+
+	for {
+		select {
+		case msg := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(DefaultWriteTimeout))
+			if err := c.conn.WriteJSON(msg); err != nil {
+				log.L.Debugf("[hub] Failed to write to client %v: %s", c.name, err)
+				c.kick("")
+				return
+			}
+		case <-ticker.C:
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(DefaultWriteTimeout)); err != nil {
+				c.kick("")
+				return
+			}
+		case <-c.done:
+			if len(c.reason) > 0 {
+				closing := websocket.FormatCloseMessage(websocket.CloseGoingAway, c.reason)
+				c.conn.WriteControl(websocket.CloseMessage, closing, time.Now().Add(DefaultWriteTimeout))
+			}
+
+			return
+		}
+	}
+}
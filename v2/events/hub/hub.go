@@ -0,0 +1,312 @@
+// Package hub streams v2 events to websocket clients, like touch panels and dashboards.
+//
+// A client subscribes by sending a subscribe message with a filter, and gets every event published to the hub's bus that matches it. Each event has a sequence number, so a client that reconnects can ask to resume after the last one it saw; the hub keeps a buffer of recent events to replay. Clients can also publish events back to the bus, which are tagged as user-generated.
+package hub
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/byuoitav/common/log"
+	"github.com/byuoitav/common/v2/events"
+	"github.com/gorilla/websocket"
+)
+
+// Message types
+const (
+	// TypeSubscribe is sent by a client to set its filter, optionally resuming after the sequence number in ResumeFrom
+	TypeSubscribe = "subscribe"
+
+	// TypePublish is sent by a client to publish Event
+	TypePublish = "publish"
+
+	// TypeSubscribed is sent to a client once its filter is set, with the sequence number of the latest event
+	TypeSubscribed = "subscribed"
+
+	// TypeEvent is an event sent to a client
+	TypeEvent = "event"
+
+	// TypeGap is sent to a resuming client when some of the events it missed are no longer buffered, or there are more than fit in its buffer. Seq is the first event that is replayed
+	TypeGap = "gap"
+
+	// TypeError is sent to a client when something it sent couldn't be handled
+	TypeError = "error"
+)
+
+// Message is sent between the hub and its clients, as JSON.
+type Message struct {
+	Type string `json:"type"`
+
+	// Seq is the event's sequence number
+	Seq uint64 `json:"seq,omitempty"`
+
+	Event *events.Event `json:"event,omitempty"`
+
+	// Filter and Match are what a client subscribes to. Both have to match if both are set; Match is an expression from the rules package
+	Filter *events.Filter `json:"filter,omitempty"`
+	Match  string         `json:"match,omitempty"`
+
+	// ResumeFrom is the sequence number of the last event the client saw
+	ResumeFrom uint64 `json:"resume-from,omitempty"`
+
+	Error string `json:"error,omitempty"`
+}
+
+// Defaults for a Hub, unless they're changed with an Option
+const (
+	DefaultHistory        = 1000
+	DefaultClientBuffer   = 256
+	DefaultPingInterval   = 30 * time.Second
+	DefaultPongTimeout    = 60 * time.Second
+	DefaultWriteTimeout   = 10 * time.Second
+	DefaultMaxMessageSize = 64 * 1024
+)
+
+// Option configures a Hub.
+type Option func(*Hub)
+
+// WithHistory sets how many recent events are kept for clients to resume from.
+func WithHistory(n int) Option {
+	return func(h *Hub) {
+		h.history = n
+	}
+}
+
+// WithClientBuffer sets how many events can be waiting to be sent to a client. A client that falls further behind is disconnected, and can resume once it reconnects.
+func WithClientBuffer(n int) Option {
+	return func(h *Hub) {
+		h.clientBuffer = n
+	}
+}
+
+// WithHeartbeat sets how often clients are pinged, and how long the hub waits to hear from a client before disconnecting it.
+func WithHeartbeat(interval, timeout time.Duration) Option {
+	return func(h *Hub) {
+		h.pingInterval = interval
+		h.pongTimeout = timeout
+	}
+}
+
+// WithCheckOrigin sets how websocket requests from other origins are allowed. By default, only requests from the same host are.
+func WithCheckOrigin(check func(r *http.Request) bool) Option {
+	return func(h *Hub) {
+		h.upgrader.CheckOrigin = check
+	}
+}
+
+// WithPublish is called for every event a client publishes, before it goes to the bus. It can fill in the event (e.g. the user from the request), or return an error to reject it.
+func WithPublish(publish func(r *http.Request, e *events.Event) error) Option {
+	return func(h *Hub) {
+		h.publish = publish
+	}
+}
+
+type entry struct {
+	seq   uint64
+	event events.Event
+}
+
+// Hub is an http.Handler that streams the events published to a bus to websocket clients. Use echo.WrapHandler to serve it with echo.
+type Hub struct {
+	bus *events.Bus
+	sub *events.Subscription
+
+	upgrader     websocket.Upgrader
+	history      int
+	clientBuffer int
+	pingInterval time.Duration
+	pongTimeout  time.Duration
+	publish      func(r *http.Request, e *events.Event) error
+
+	mu      sync.Mutex
+	seq     uint64
+	recent  []entry
+	clients map[*client]struct{}
+	closed  bool
+	done    chan struct{}
+}
+
+// New creates a hub for every event published to bus.
+func New(bus *events.Bus, opts ...Option) *Hub {
+	h := &Hub{
+		bus:          bus,
+		history:      DefaultHistory,
+		clientBuffer: DefaultClientBuffer,
+		pingInterval: DefaultPingInterval,
+		pongTimeout:  DefaultPongTimeout,
+		clients:      make(map[*client]struct{}),
+		done:         make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(h)
+	}
+
+	// the hub never blocks for long, since it drops slow clients instead of waiting on them
+	h.sub = bus.Subscribe(events.Filter{}, events.WithPolicy(events.Block))
+
+	go h.run()
+	return h
+}
+
+func (h *Hub) run() {
+	defer close(h.done)
+
+	for e := range h.sub.C {
+		h.broadcast(e)
+	}
+}
+
+// broadcast numbers e, saves it for clients that resume, and sends it to every client whose filter it matches
+func (h *Hub) broadcast(e events.Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.seq++
+	ent := entry{seq: h.seq, event: e}
+
+	if h.history > 0 {
+		if len(h.recent) >= h.history {
+			h.recent = h.recent[len(h.recent)-h.history+1:]
+		}
+
+		h.recent = append(h.recent, ent)
+	}
+
+	for c := range h.clients {
+		c.deliver(ent)
+	}
+}
+
+// ServeHTTP upgrades the request to a websocket, and serves the client until it disconnects.
+func (h *Hub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.L.Warnf("[hub] Failed to upgrade websocket from %v: %s", r.RemoteAddr, err)
+		return
+	}
+
+	c := newClient(h, conn, r)
+
+	h.mu.Lock()
+	if h.closed {
+		h.mu.Unlock()
+		c.kick("hub is closed")
+		c.writePump()
+		return
+	}
+
+	h.clients[c] = struct{}{}
+	h.mu.Unlock()
+
+	log.L.Debugf("[hub] Client %v connected", c.name)
+
+	go c.writePump()
+	c.readPump()
+
+	h.mu.Lock()
+	delete(h.clients, c)
+	h.mu.Unlock()
+
+	log.L.Debugf("[hub] Client %v disconnected", c.name)
+}
+
+// subscribe sets c's filter, and replays what it missed if it is resuming
+func (h *Hub) subscribe(c *client, msg Message, m matcher) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	c.setMatcher(m)
+
+	if from := msg.ResumeFrom; from > 0 {
+		gap := false
+		if from > h.seq {
+			// the hub has restarted since the client last saw it
+			from = 0
+			gap = true
+		}
+
+		missed := h.recent
+		for len(missed) > 0 && missed[0].seq <= from {
+			missed = missed[1:]
+		}
+
+		first := h.seq + 1
+		if len(missed) > 0 {
+			first = missed[0].seq
+		}
+
+		if first > from+1 {
+			gap = true
+		}
+
+		var replay []entry
+		for _, ent := range missed {
+			if m.matches(ent.event) {
+				replay = append(replay, ent)
+			}
+		}
+
+		// only replay what fits in the client's buffer (leaving room for the gap and subscribed messages), so that it isn't disconnected for being too far behind
+		free := cap(c.send) - len(c.send) - 2
+		if free < 0 {
+			free = 0
+		}
+
+		if len(replay) > free {
+			log.L.Infof("[hub] Client %v missed %v events, only replaying the last %v", c.name, len(replay), free)
+
+			replay = replay[len(replay)-free:]
+			gap = true
+
+			first = h.seq + 1
+			if len(replay) > 0 {
+				first = replay[0].seq
+			}
+		}
+
+		if gap {
+			c.queue(Message{Type: TypeGap, Seq: first})
+		}
+
+		for _, ent := range replay {
+			c.deliver(ent)
+		}
+	}
+
+	c.queue(Message{Type: TypeSubscribed, Seq: h.seq})
+}
+
+// Seq returns the sequence number of the latest event.
+func (h *Hub) Seq() uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.seq
+}
+
+// Clients returns how many clients are connected.
+func (h *Hub) Clients() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return len(h.clients)
+}
+
+// Close disconnects every client and stops listening to the bus.
+func (h *Hub) Close() {
+	h.mu.Lock()
+	h.closed = true
+
+	clients := h.clients
+	h.clients = make(map[*client]struct{})
+	h.mu.Unlock()
+
+	for c := range clients {
+		c.kick("hub is closed")
+	}
+
+	h.sub.Close()
+	<-h.done
+}
@@ -0,0 +1,153 @@
+package hub
+
+import (
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/byuoitav/common/v2/events"
+	"github.com/gorilla/websocket"
+)
+
+func dial(t *testing.T, url string) *websocket.Conn {
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(url, "http"), nil)
+	if err != nil {
+		t.Fatalf("failed to connect: %s", err)
+	}
+
+	return conn
+}
+
+func read(t *testing.T, conn *websocket.Conn, typ string) Message {
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+
+	var msg Message
+	if err := conn.ReadJSON(&msg); err != nil {
+		t.Fatalf("failed to read %s message: %s", typ, err)
+	}
+
+	if msg.Type != typ {
+		t.Fatalf("expected a %s message, got %+v", typ, msg)
+	}
+
+	return msg
+}
+
+func TestHub(t *testing.T) {
+	bus := events.NewBus()
+	hub := New(bus, WithHistory(2))
+	defer hub.Close()
+
+	srv := httptest.NewServer(hub)
+	defer srv.Close()
+
+	conn := dial(t, srv.URL)
+	conn.WriteJSON(Message{Type: TypeSubscribe, Filter: &events.Filter{RoomIDs: []string{"ITB-1101"}}, Match: `key == "power"`})
+	read(t, conn, TypeSubscribed)
+
+	room := events.GenerateBasicRoomInfo("ITB-1101")
+	bus.Publish(events.Event{Key: "input", AffectedRoom: room})
+	bus.Publish(events.Event{Key: "power", Value: "on", AffectedRoom: room})
+
+	msg := read(t, conn, TypeEvent)
+	if msg.Event.Value != "on" || msg.Seq != 2 {
+		t.Fatalf("expected the power event, got %+v", msg)
+	}
+
+	// publishing from the client goes through the bus, and back to us
+	conn.WriteJSON(Message{Type: TypePublish, Event: &events.Event{Key: "power", Value: "standby", AffectedRoom: room}})
+
+	msg = read(t, conn, TypeEvent)
+	if msg.Event.Value != "standby" || !events.ContainsAllTags(*msg.Event, events.UserGenerated) {
+		t.Fatalf("expected the user generated power event, got %+v", msg)
+	}
+
+	conn.Close()
+
+	// miss a few events, and then resume
+	bus.Publish(events.Event{Key: "power", Value: "on", AffectedRoom: room})
+	bus.Publish(events.Event{Key: "power", Value: "off", AffectedRoom: room})
+	for hub.Seq() != 5 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	conn = dial(t, srv.URL)
+	defer conn.Close()
+
+	conn.WriteJSON(Message{Type: TypeSubscribe, Filter: &events.Filter{RoomIDs: []string{"ITB-1101"}}, ResumeFrom: 2})
+
+	// only two events are kept, so the third is lost
+	if msg := read(t, conn, TypeGap); msg.Seq != 4 {
+		t.Fatalf("expected events to be replayed from 4, got %+v", msg)
+	}
+
+	if msg := read(t, conn, TypeEvent); msg.Seq != 4 || msg.Event.Value != "on" {
+		t.Fatalf("expected the missed power on, got %+v", msg)
+	}
+
+	if msg := read(t, conn, TypeEvent); msg.Seq != 5 || msg.Event.Value != "off" {
+		t.Fatalf("expected the missed power off, got %+v", msg)
+	}
+
+	if msg := read(t, conn, TypeSubscribed); msg.Seq != 5 {
+		t.Fatalf("expected to be caught up to 5, got %+v", msg)
+	}
+
+	conn.WriteJSON(Message{Type: TypeSubscribe, Match: `key ==`})
+	if msg := read(t, conn, TypeError); !strings.Contains(msg.Error, "column 7") {
+		t.Fatalf("expected an error with the position, got %+v", msg)
+	}
+}
+
+func TestHubResumeFullBuffer(t *testing.T) {
+	bus := events.NewBus()
+	hub := New(bus)
+	defer hub.Close()
+
+	srv := httptest.NewServer(hub)
+	defer srv.Close()
+
+	conn := dial(t, srv.URL)
+	conn.WriteJSON(Message{Type: TypeSubscribe})
+	read(t, conn, TypeSubscribed)
+
+	bus.Publish(events.Event{Key: "power", Value: "0"})
+	read(t, conn, TypeEvent)
+	conn.Close()
+
+	// miss more events than fit in the client's buffer
+	missed := DefaultClientBuffer + 50
+	for i := 1; i <= missed; i++ {
+		bus.Publish(events.Event{Key: "power", Value: strconv.Itoa(i)})
+	}
+
+	last := uint64(missed + 1)
+	for hub.Seq() != last {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	conn = dial(t, srv.URL)
+	defer conn.Close()
+
+	conn.WriteJSON(Message{Type: TypeSubscribe, ResumeFrom: 1})
+
+	// the oldest events are skipped, instead of the client being disconnected partway through
+	replayed := uint64(DefaultClientBuffer - 2)
+	first := last - replayed + 1
+
+	if msg := read(t, conn, TypeGap); msg.Seq != first {
+		t.Fatalf("expected events to be replayed from %v, got %+v", first, msg)
+	}
+
+	for seq := first; seq <= last; seq++ {
+		if msg := read(t, conn, TypeEvent); msg.Seq != seq {
+			t.Fatalf("expected event %v, got %+v", seq, msg)
+		}
+	}
+
+	if msg := read(t, conn, TypeSubscribed); msg.Seq != last {
+		t.Fatalf("expected to be caught up to %v, got %+v", last, msg)
+	}
+}
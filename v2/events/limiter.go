@@ -0,0 +1,231 @@
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// LimiterConfig configures a Limiter. Limits that aren't set aren't applied.
+type LimiterConfig struct {
+	// Window suppresses an event if an event with the same device, key, and value was passed on within it
+	Window time.Duration `json:"window"`
+
+	// ChangeTags are tags whose events are only passed on when their value changes from the last one passed on for the same device and key
+	ChangeTags []string `json:"change-tags"`
+
+	// Rate is how many events per second each device can send on average, and Burst is how many it can send at once
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+// DefaultLimiterConfig drops repeated events within a second, only passes on changes to core state, and limits each device to 10 events per second.
+var DefaultLimiterConfig = LimiterConfig{
+	Window:     time.Second,
+	ChangeTags: []string{CoreState},
+	Rate:       10,
+	Burst:      20,
+}
+
+// DroppedCounts is how many events a Limiter has dropped, by reason.
+type DroppedCounts struct {
+	Duplicate   uint64 `json:"duplicate"`
+	Unchanged   uint64 `json:"unchanged"`
+	RateLimited uint64 `json:"rate-limited"`
+}
+
+// Total returns how many events were dropped for any reason.
+func (d DroppedCounts) Total() uint64 {
+	return d.Duplicate + d.Unchanged + d.RateLimited
+}
+
+// LimiterStats is how many events a Limiter has passed on and dropped.
+type LimiterStats struct {
+	Passed  uint64        `json:"passed"`
+	Dropped DroppedCounts `json:"dropped"`
+
+	// Devices is how many events were dropped from each device
+	Devices map[string]DroppedCounts `json:"devices"`
+}
+
+type stateKey struct {
+	device string
+	key    string
+}
+
+type dupKey struct {
+	stateKey
+	value string
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Limiter drops duplicate and excessive events before they're passed on, so that noisy devices don't flood everything downstream.
+// Events are grouped by their target device, or their generating system if they don't have one.
+type Limiter struct {
+	config LimiterConfig
+	next   func(Event)
+	now    func() time.Time
+
+	mu        sync.Mutex
+	emitted   map[dupKey]time.Time
+	values    map[stateKey]string
+	buckets   map[string]*bucket
+	stats     LimiterStats
+	lastSweep time.Time
+}
+
+// NewLimiter creates a limiter that passes the events it allows on to next, which may be nil if Allow is used directly.
+func NewLimiter(config LimiterConfig, next func(Event)) *Limiter {
+	return &Limiter{
+		config:  config,
+		next:    next,
+		now:     time.Now,
+		emitted: make(map[dupKey]time.Time),
+		values:  make(map[stateKey]string),
+		buckets: make(map[string]*bucket),
+		stats: LimiterStats{
+			Devices: make(map[string]DroppedCounts),
+		},
+	}
+}
+
+// Publish passes e on if the limiter allows it. It can be used anywhere a func(Event) is expected, e.g. in front of a bus's Publish.
+func (l *Limiter) Publish(e Event) {
+	if l.Allow(e) && l.next != nil {
+		l.next(e)
+	}
+}
+
+// Allow returns true if e should be passed on, and records it as passed on if so.
+func (l *Limiter) Allow(e Event) bool {
+	now := l.now()
+
+	device := e.TargetDevice.DeviceID
+	if len(device) == 0 {
+		device = e.GeneratingSystem
+	}
+
+	sk := stateKey{device: device, key: e.Key}
+	dk := dupKey{stateKey: sk, value: e.Value}
+	changeOnly := len(l.config.ChangeTags) > 0 && ContainsAnyTags(e, l.config.ChangeTags...)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+
+	if changeOnly {
+		if last, ok := l.values[sk]; ok && last == e.Value {
+			l.drop(device, func(d *DroppedCounts) { d.Unchanged++ })
+			return false
+		}
+	}
+
+	if l.config.Window > 0 {
+		if last, ok := l.emitted[dk]; ok && now.Sub(last) < l.config.Window {
+			l.drop(device, func(d *DroppedCounts) { d.Duplicate++ })
+			return false
+		}
+	}
+
+	if l.config.Rate > 0 && !l.take(device, now) {
+		l.drop(device, func(d *DroppedCounts) { d.RateLimited++ })
+		return false
+	}
+
+	if changeOnly {
+		l.values[sk] = e.Value
+	}
+
+	if l.config.Window > 0 {
+		l.emitted[dk] = now
+	}
+
+	l.stats.Passed++
+	return true
+}
+
+// burst is how many tokens a bucket holds, which is always at least one
+func (l *Limiter) burst() float64 {
+	if l.config.Burst < 1 {
+		return 1
+	}
+
+	return float64(l.config.Burst)
+}
+
+// take takes a token from device's bucket, returning false if there aren't any
+func (l *Limiter) take(device string, now time.Time) bool {
+	burst := l.burst()
+
+	b, ok := l.buckets[device]
+	if !ok {
+		b = &bucket{tokens: burst, last: now}
+		l.buckets[device] = b
+	}
+
+	b.tokens += now.Sub(b.last).Seconds() * l.config.Rate
+	if b.tokens > burst {
+		b.tokens = burst
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+
+	b.tokens--
+	return true
+}
+
+func (l *Limiter) drop(device string, count func(*DroppedCounts)) {
+	count(&l.stats.Dropped)
+
+	d := l.stats.Devices[device]
+	count(&d)
+	l.stats.Devices[device] = d
+}
+
+// sweep forgets about duplicates that are outside of the window, and full buckets, so that the limiter doesn't grow forever
+func (l *Limiter) sweep(now time.Time) {
+	interval := l.config.Window
+	if interval < time.Minute {
+		interval = time.Minute
+	}
+
+	if now.Sub(l.lastSweep) < interval {
+		return
+	}
+
+	l.lastSweep = now
+
+	for k, t := range l.emitted {
+		if now.Sub(t) >= l.config.Window {
+			delete(l.emitted, k)
+		}
+	}
+
+	burst := l.burst()
+	for device, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.config.Rate >= burst {
+			delete(l.buckets, device)
+		}
+	}
+}
+
+// Stats returns how many events have been passed on and dropped.
+func (l *Limiter) Stats() LimiterStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	stats := l.stats
+	stats.Devices = make(map[string]DroppedCounts, len(l.stats.Devices))
+	for device, d := range l.stats.Devices {
+		stats.Devices[device] = d
+	}
+
+	return stats
+}
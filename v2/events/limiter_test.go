@@ -0,0 +1,72 @@
+package events
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	now := time.Now()
+
+	var passed []Event
+	l := NewLimiter(LimiterConfig{Window: time.Second, ChangeTags: []string{CoreState}, Rate: 1, Burst: 3}, func(e Event) {
+		passed = append(passed, e)
+	})
+	l.now = func() time.Time { return now }
+
+	d1 := GenerateBasicDeviceInfo("ITB-1101-D1")
+	volume := func(v string) Event {
+		return Event{Key: "volume", Value: v, TargetDevice: d1, EventTags: []string{DetailState}}
+	}
+	power := func(v string) Event {
+		return Event{Key: "power", Value: v, TargetDevice: d1, EventTags: []string{CoreState}}
+	}
+
+	l.Publish(power("on"))
+	l.Publish(power("on"))
+	l.Publish(volume("30"))
+	l.Publish(volume("30"))
+
+	if len(passed) != 2 {
+		t.Fatalf("expected the repeated events to be dropped, got %v events", len(passed))
+	}
+
+	// the duplicate window has passed, but power hasn't changed
+	now = now.Add(2 * time.Second)
+	l.Publish(power("on"))
+	l.Publish(volume("30"))
+	l.Publish(volume("31"))
+	l.Publish(volume("32"))
+	l.Publish(volume("33"))
+
+	if len(passed) != 5 {
+		t.Fatalf("expected the burst to be limited, got %v events", len(passed))
+	}
+
+	stats := l.Stats()
+	dropped := stats.Devices["ITB-1101-D1"]
+	if stats.Passed != 5 || dropped.Unchanged != 2 || dropped.Duplicate != 1 || dropped.RateLimited != 1 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestLimiterSweep(t *testing.T) {
+	now := time.Now()
+
+	var passed []Event
+	l := NewLimiter(LimiterConfig{Rate: 0.01}, func(e Event) {
+		passed = append(passed, e)
+	})
+	l.now = func() time.Time { return now }
+
+	d1 := GenerateBasicDeviceInfo("ITB-1101-D1")
+	l.Publish(Event{Key: "volume", Value: "30", TargetDevice: d1})
+
+	// the bucket only refills one token every 100 seconds, so it isn't full yet when it's swept
+	now = now.Add(61 * time.Second)
+	l.Publish(Event{Key: "volume", Value: "31", TargetDevice: d1})
+
+	if len(passed) != 1 {
+		t.Fatalf("expected the second event to be rate limited, got %v events", len(passed))
+	}
+}
@@ -0,0 +1,325 @@
+// Package rules compiles event filter expressions, and loads rule files made of them, so that events can be routed without hard-coding the checks.
+//
+// An expression compares fields of an event, and combines comparisons with and, or, not, and parentheses:
+//
+//	tags has "core-state" and key in ["power", "input"] and room ~ "ITB-.*"
+//
+// The fields are tags, key, value, user, room, building, device, and system (the generating system). tags supports has, has any [...], and has all [...].
+// The other fields support == and != (room, building, and device ignore case), in [...], and ~, which matches the whole field against a regular expression.
+package rules
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/byuoitav/common/v2/events"
+)
+
+// Expr is a compiled expression.
+type Expr struct {
+	src   string
+	match predicate
+}
+
+type predicate func(events.Event) bool
+
+// fields are the string fields that can be compared, and whether they're compared without case
+var fields = map[string]struct {
+	get  func(events.Event) string
+	fold bool
+}{
+	"key":      {get: func(e events.Event) string { return e.Key }},
+	"value":    {get: func(e events.Event) string { return e.Value }},
+	"user":     {get: func(e events.Event) string { return e.User }},
+	"system":   {get: func(e events.Event) string { return e.GeneratingSystem }},
+	"room":     {get: func(e events.Event) string { return e.AffectedRoom.RoomID }, fold: true},
+	"building": {get: func(e events.Event) string { return e.AffectedRoom.BuildingID }, fold: true},
+	"device":   {get: func(e events.Event) string { return e.TargetDevice.DeviceID }, fold: true},
+}
+
+// Compile parses an expression. Any problem with it is returned as an *Error, which says where in the expression it is.
+func Compile(src string) (*Expr, error) {
+	tokens, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{src: src, tokens: tokens}
+
+	if p.peek().kind == tokenEOF {
+		return nil, p.errorf(p.peek(), "expression is empty")
+	}
+
+	match, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+
+	if tok := p.peek(); tok.kind != tokenEOF {
+		return nil, p.errorf(tok, "expected and, or, or the end of the expression, got %s", tok)
+	}
+
+	return &Expr{src: src, match: match}, nil
+}
+
+// MustCompile is like Compile, but panics if the expression is invalid.
+func MustCompile(src string) *Expr {
+	expr, err := Compile(src)
+	if err != nil {
+		panic(fmt.Sprintf("rules: invalid expression %q: %s", src, err))
+	}
+
+	return expr
+}
+
+// Matches returns true if e matches the expression.
+func (x *Expr) Matches(e events.Event) bool {
+	return x.match(e)
+}
+
+func (x *Expr) String() string {
+	return x.src
+}
+
+type parser struct {
+	src    string
+	tokens []token
+	i      int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.i]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.i]
+	if tok.kind != tokenEOF {
+		p.i++
+	}
+
+	return tok
+}
+
+func (p *parser) errorf(tok token, format string, args ...interface{}) error {
+	return &Error{Pos: position(p.src, tok.pos), Msg: fmt.Sprintf(format, args...)}
+}
+
+// keyword returns true (and consumes it) if the next token is the word kw
+func (p *parser) keyword(kw string) bool {
+	if tok := p.peek(); tok.kind == tokenIdent && strings.EqualFold(tok.text, kw) {
+		p.next()
+		return true
+	}
+
+	return false
+}
+
+func (p *parser) or() (predicate, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.keyword("or") {
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+
+		l := left
+		left = func(e events.Event) bool { return l(e) || right(e) }
+	}
+
+	return left, nil
+}
+
+func (p *parser) and() (predicate, error) {
+	left, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.keyword("and") {
+		right, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+
+		l := left
+		left = func(e events.Event) bool { return l(e) && right(e) }
+	}
+
+	return left, nil
+}
+
+func (p *parser) not() (predicate, error) {
+	if p.keyword("not") {
+		inner, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+
+		return func(e events.Event) bool { return !inner(e) }, nil
+	}
+
+	return p.primary()
+}
+
+func (p *parser) primary() (predicate, error) {
+	tok := p.next()
+
+	switch {
+	case tok.kind == tokenLParen:
+		inner, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+
+		if end := p.next(); end.kind != tokenRParen {
+			return nil, p.errorf(end, "expected ')' to close the '(' at %s, got %s", position(p.src, tok.pos), end)
+		}
+
+		return inner, nil
+	case tok.kind != tokenIdent:
+		return nil, p.errorf(tok, "expected a field name, not, or '(', got %s", tok)
+	}
+
+	name := strings.ToLower(tok.text)
+
+	switch name {
+	case "true":
+		return func(events.Event) bool { return true }, nil
+	case "false":
+		return func(events.Event) bool { return false }, nil
+	case "tags":
+		return p.tags()
+	}
+
+	field, ok := fields[name]
+	if !ok {
+		return nil, p.errorf(tok, "unknown field %s: must be one of tags, key, value, user, room, building, device, or system", tok)
+	}
+
+	get := field.get
+	equal := func(a, b string) bool { return a == b }
+	if field.fold {
+		equal = strings.EqualFold
+	}
+
+	op := p.next()
+
+	switch {
+	case op.kind == tokenOp && (op.text == "==" || op.text == "!="):
+		s, err := p.str()
+		if err != nil {
+			return nil, err
+		}
+
+		if op.text == "!=" {
+			return func(e events.Event) bool { return !equal(get(e), s) }, nil
+		}
+
+		return func(e events.Event) bool { return equal(get(e), s) }, nil
+	case op.kind == tokenOp && op.text == "~":
+		s, err := p.str()
+		if err != nil {
+			return nil, err
+		}
+
+		re, err := regexp.Compile("^(?:" + s + ")$")
+		if err != nil {
+			return nil, p.errorf(p.tokens[p.i-1], "invalid regular expression: %s", err)
+		}
+
+		return func(e events.Event) bool { return re.MatchString(get(e)) }, nil
+	case op.kind == tokenIdent && strings.EqualFold(op.text, "in"):
+		list, err := p.list()
+		if err != nil {
+			return nil, err
+		}
+
+		return func(e events.Event) bool {
+			val := get(e)
+			for _, s := range list {
+				if equal(val, s) {
+					return true
+				}
+			}
+
+			return false
+		}, nil
+	}
+
+	return nil, p.errorf(op, "expected ==, !=, ~, or in after %s, got %s", name, op)
+}
+
+// tags parses the rest of a comparison on tags
+func (p *parser) tags() (predicate, error) {
+	if op := p.next(); op.kind != tokenIdent || !strings.EqualFold(op.text, "has") {
+		return nil, p.errorf(op, "expected has after tags, got %s", op)
+	}
+
+	switch {
+	case p.keyword("any"):
+		list, err := p.list()
+		if err != nil {
+			return nil, err
+		}
+
+		return func(e events.Event) bool { return events.ContainsAnyTags(e, list...) }, nil
+	case p.keyword("all"):
+		list, err := p.list()
+		if err != nil {
+			return nil, err
+		}
+
+		return func(e events.Event) bool { return events.ContainsAllTags(e, list...) }, nil
+	}
+
+	tag, err := p.str()
+	if err != nil {
+		return nil, err
+	}
+
+	return func(e events.Event) bool { return events.ContainsAllTags(e, tag) }, nil
+}
+
+func (p *parser) str() (string, error) {
+	tok := p.next()
+	if tok.kind != tokenString {
+		return "", p.errorf(tok, "expected a string, got %s", tok)
+	}
+
+	return tok.text, nil
+}
+
+// list parses a list of strings, like ["a", "b"]
+func (p *parser) list() ([]string, error) {
+	if tok := p.next(); tok.kind != tokenLBracket {
+		return nil, p.errorf(tok, "expected '[' to start a list, got %s", tok)
+	}
+
+	var list []string
+	for {
+		if p.peek().kind == tokenRBracket && len(list) == 0 {
+			p.next()
+			return list, nil
+		}
+
+		s, err := p.str()
+		if err != nil {
+			return nil, err
+		}
+
+		list = append(list, s)
+
+		switch tok := p.next(); tok.kind {
+		case tokenComma:
+		case tokenRBracket:
+			return list, nil
+		default:
+			return nil, p.errorf(tok, "expected ',' or ']' in list, got %s", tok)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package rules
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenString
+	tokenOp
+	tokenLParen
+	tokenRParen
+	tokenLBracket
+	tokenRBracket
+	tokenComma
+)
+
+func (k tokenKind) String() string {
+	switch k {
+	case tokenEOF:
+		return "end of expression"
+	case tokenIdent:
+		return "word"
+	case tokenString:
+		return "string"
+	case tokenOp:
+		return "operator"
+	case tokenLParen:
+		return "'('"
+	case tokenRParen:
+		return "')'"
+	case tokenLBracket:
+		return "'['"
+	case tokenRBracket:
+		return "']'"
+	case tokenComma:
+		return "','"
+	default:
+		return "unknown token"
+	}
+}
+
+type token struct {
+	kind tokenKind
+	pos  int
+
+	// text is the token as written, except for strings, where it's the unquoted value
+	text string
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokenEOF:
+		return t.kind.String()
+	case tokenString:
+		return strconv.Quote(t.text)
+	default:
+		return fmt.Sprintf("%q", t.text)
+	}
+}
+
+// Position is a location in an expression.
+type Position struct {
+	// Offset is the byte offset, starting at 0
+	Offset int `json:"offset"`
+
+	// Line and Column start at 1
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+func (p Position) String() string {
+	return fmt.Sprintf("line %d, column %d", p.Line, p.Column)
+}
+
+func position(src string, offset int) Position {
+	p := Position{Offset: offset, Line: 1, Column: 1}
+
+	for _, r := range src[:offset] {
+		if r == '\n' {
+			p.Line++
+			p.Column = 1
+		} else {
+			p.Column++
+		}
+	}
+
+	return p
+}
+
+// Error is a problem with an expression, and where it is.
+type Error struct {
+	Pos Position `json:"position"`
+	Msg string   `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Pos, e.Msg)
+}
+
+func lex(src string) ([]token, error) {
+	var tokens []token
+
+	for i := 0; i < len(src); {
+		c := src[i]
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(':
+			tokens = append(tokens, token{kind: tokenLParen, pos: i, text: "("})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{kind: tokenRParen, pos: i, text: ")"})
+			i++
+		case c == '[':
+			tokens = append(tokens, token{kind: tokenLBracket, pos: i, text: "["})
+			i++
+		case c == ']':
+			tokens = append(tokens, token{kind: tokenRBracket, pos: i, text: "]"})
+			i++
+		case c == ',':
+			tokens = append(tokens, token{kind: tokenComma, pos: i, text: ","})
+			i++
+		case c == '~':
+			tokens = append(tokens, token{kind: tokenOp, pos: i, text: "~"})
+			i++
+		case strings.HasPrefix(src[i:], "=="), strings.HasPrefix(src[i:], "!="):
+			tokens = append(tokens, token{kind: tokenOp, pos: i, text: src[i : i+2]})
+			i += 2
+		case c == '"':
+			end := i + 1
+			for ; end < len(src) && src[end] != '"'; end++ {
+				if src[end] == '\\' {
+					end++
+				}
+			}
+
+			if end >= len(src) {
+				return nil, &Error{Pos: position(src, i), Msg: "string is never closed"}
+			}
+
+			s, err := strconv.Unquote(src[i : end+1])
+			if err != nil {
+				return nil, &Error{Pos: position(src, i), Msg: fmt.Sprintf("invalid string %s", src[i:end+1])}
+			}
+
+			tokens = append(tokens, token{kind: tokenString, pos: i, text: s})
+			i = end + 1
+		case isIdent(rune(c)):
+			end := i
+			for end < len(src) && isIdent(rune(src[end])) {
+				end++
+			}
+
+			tokens = append(tokens, token{kind: tokenIdent, pos: i, text: src[i:end]})
+			i = end
+		default:
+			return nil, &Error{Pos: position(src, i), Msg: fmt.Sprintf("unexpected character %q", c)}
+		}
+	}
+
+	tokens = append(tokens, token{kind: tokenEOF, pos: len(src)})
+	return tokens, nil
+}
+
+func isIdent(r rune) bool {
+	return r == '_' || r == '-' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
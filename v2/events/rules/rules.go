@@ -0,0 +1,163 @@
+package rules
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/byuoitav/common/v2/events"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Rule routes the events that match its expression to its destinations.
+type Rule struct {
+	// Name identifies the rule in errors and logs
+	Name string `json:"name" yaml:"name"`
+
+	// Match is the expression an event has to match
+	Match string `json:"match" yaml:"match"`
+
+	// Destinations are where matching events go. What they mean is up to whoever uses the rules, e.g. a url or a subscription name
+	Destinations []string `json:"destinations" yaml:"destinations"`
+
+	expr *Expr
+}
+
+// Matches returns true if e matches the rule. A rule that hasn't been compiled (by Compile, Parse, or LoadFile) doesn't match anything.
+func (r Rule) Matches(e events.Event) bool {
+	return r.expr != nil && r.expr.Matches(e)
+}
+
+// RuleError is a problem with one of the rules in a set.
+type RuleError struct {
+	// Index is the rule's position in the set, starting at 0
+	Index int
+	Name  string
+	Err   error
+}
+
+func (e *RuleError) Error() string {
+	if len(e.Name) > 0 {
+		return fmt.Sprintf("rule %d (%s): %s", e.Index, e.Name, e.Err)
+	}
+
+	return fmt.Sprintf("rule %d: %s", e.Index, e.Err)
+}
+
+// Rules is an ordered set of rules.
+type Rules []Rule
+
+// Compile compiles each rule's expression. The first problem found is returned as a *RuleError.
+func (rs Rules) Compile() error {
+	for i := range rs {
+		if len(strings.TrimSpace(rs[i].Match)) == 0 {
+			return &RuleError{Index: i, Name: rs[i].Name, Err: fmt.Errorf("match is empty")}
+		}
+
+		expr, err := Compile(rs[i].Match)
+		if err != nil {
+			return &RuleError{Index: i, Name: rs[i].Name, Err: err}
+		}
+
+		rs[i].expr = expr
+	}
+
+	return nil
+}
+
+// Match returns each rule that e matches, in order.
+func (rs Rules) Match(e events.Event) Rules {
+	var matched Rules
+
+	for _, r := range rs {
+		if r.Matches(e) {
+			matched = append(matched, r)
+		}
+	}
+
+	return matched
+}
+
+// Destinations returns the destinations of every rule e matches, without duplicates.
+func (rs Rules) Destinations(e events.Event) []string {
+	seen := make(map[string]bool)
+	dests := []string{}
+
+	for _, r := range rs.Match(e) {
+		for _, d := range r.Destinations {
+			if !seen[d] {
+				seen[d] = true
+				dests = append(dests, d)
+			}
+		}
+	}
+
+	return dests
+}
+
+// Parse parses and compiles rules. format is either json or yaml (or yml). Problems with a rule are returned as a *RuleError.
+// The rules can either be a list, or an object with the list under "rules".
+func Parse(data []byte, format string) (Rules, error) {
+	var rs Rules
+	var wrapped struct {
+		Rules Rules `json:"rules" yaml:"rules"`
+	}
+
+	switch strings.ToLower(format) {
+	case "json":
+		if err := unmarshalStrict(data, &rs); err != nil {
+			if werr := unmarshalStrict(data, &wrapped); werr != nil {
+				return nil, fmt.Errorf("invalid json rules: %s", err)
+			}
+
+			rs = wrapped.Rules
+		}
+	case "yaml", "yml":
+		if err := yaml.UnmarshalStrict(data, &rs); err != nil {
+			if werr := yaml.UnmarshalStrict(data, &wrapped); werr != nil {
+				return nil, fmt.Errorf("invalid yaml rules: %s", err)
+			}
+
+			rs = wrapped.Rules
+		}
+	default:
+		return nil, fmt.Errorf("unknown rule format %q: must be json or yaml", format)
+	}
+
+	if err := rs.Compile(); err != nil {
+		return nil, err
+	}
+
+	return rs, nil
+}
+
+// unmarshalStrict is json.Unmarshal, but fails on fields that v doesn't have, like yaml.UnmarshalStrict
+func unmarshalStrict(data []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+
+	if _, err := dec.Token(); err != io.EOF {
+		return errors.New("unexpected data after the rules")
+	}
+
+	return nil
+}
+
+// LoadFile reads rules from a .json, .yaml, or .yml file. Problems with a rule are returned as a *RuleError, like Parse.
+func LoadFile(path string) (Rules, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read rules: %s", err)
+	}
+
+	return Parse(data, strings.TrimPrefix(filepath.Ext(path), "."))
+}
@@ -0,0 +1,113 @@
+package rules
+
+import (
+	"testing"
+
+	"github.com/byuoitav/common/v2/events"
+)
+
+var power = events.Event{
+	Key:          "power",
+	Value:        "on",
+	EventTags:    []string{events.CoreState, events.AutoGenerated},
+	AffectedRoom: events.GenerateBasicRoomInfo("ITB-1101"),
+	TargetDevice: events.GenerateBasicDeviceInfo("ITB-1101-D1"),
+}
+
+func TestExpressions(t *testing.T) {
+	tests := []struct {
+		expr  string
+		match bool
+	}{
+		{`tags has "core-state" and key in ["power", "input"] and room ~ "ITB-.*"`, true},
+		{`tags has "core-state" and key in ["input"]`, false},
+		{`room ~ "ITB"`, false},
+		{`device == "itb-1101-d1" and building != "JFSB"`, true},
+		{`key == "POWER"`, false},
+		{`tags has any ["heartbeat", "auto-generated"]`, true},
+		{`tags has all ["core-state", "heartbeat"]`, false},
+		{`not (value == "off" or user == "bob") and true`, true},
+		{`key == "input" or key == "power" and value == "on"`, true},
+		{`(key == "input" or key == "power") and value == "standby"`, false},
+	}
+
+	for _, test := range tests {
+		expr, err := Compile(test.expr)
+		if err != nil {
+			t.Fatalf("failed to compile %s: %s", test.expr, err)
+		}
+
+		if expr.Matches(power) != test.match {
+			t.Errorf("expected %s to return %v", test.expr, test.match)
+		}
+	}
+}
+
+func TestExpressionErrors(t *testing.T) {
+	tests := []struct {
+		expr   string
+		line   int
+		column int
+	}{
+		{``, 1, 1},
+		{`key = "power"`, 1, 5},
+		{`color == "red"`, 1, 1},
+		{`key == "power" and`, 1, 19},
+		{"key == \"power\"\n  and room ~ \"ITB-(\"", 2, 14},
+		{`(key == "power"`, 1, 16},
+		{`key in ["power" "input"]`, 1, 17},
+		{`tags contains "x"`, 1, 6},
+		{`key == "power`, 1, 8},
+	}
+
+	for _, test := range tests {
+		_, err := Compile(test.expr)
+		if err == nil {
+			t.Errorf("expected an error compiling %q", test.expr)
+			continue
+		}
+
+		perr, ok := err.(*Error)
+		if !ok {
+			t.Errorf("expected an *Error compiling %q, got %T", test.expr, err)
+			continue
+		}
+
+		if perr.Pos.Line != test.line || perr.Pos.Column != test.column {
+			t.Errorf("expected the error compiling %q to be at line %d, column %d, got %s", test.expr, test.line, test.column, perr)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	yamlRules := `
+rules:
+  - name: state
+    match: tags has any ["core-state", "detail-state"]
+    destinations: [elk, couch]
+  - name: itb
+    match: building == "ITB"
+    destinations: [elk]
+`
+
+	rules, err := Parse([]byte(yamlRules), "yaml")
+	if err != nil {
+		t.Fatalf("failed to parse yaml rules: %s", err)
+	}
+
+	if dests := rules.Destinations(power); len(dests) != 2 || dests[0] != "elk" || dests[1] != "couch" {
+		t.Fatalf("expected elk and couch, got %v", dests)
+	}
+
+	// misspelled fields are errors, not ignored
+	if _, err := Parse([]byte(`[{"name": "state", "match": "key == \"power\"", "destination": ["elk"]}]`), "json"); err == nil {
+		t.Fatalf("expected an error for an unknown field")
+	}
+
+	jsonRules := `[{"name": "state", "match": "tags has"}]`
+
+	_, err = Parse([]byte(jsonRules), "json")
+	if rerr, ok := err.(*RuleError); !ok || rerr.Name != "state" {
+		t.Fatalf("expected an error for the state rule, got %v", err)
+	}
+}
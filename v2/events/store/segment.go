@@ -0,0 +1,285 @@
+package store
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/byuoitav/common/log"
+	"github.com/byuoitav/common/v2/events"
+)
+
+const (
+	// recordHeaderSize is the length and crc32 that come before each record's json
+	recordHeaderSize = 8
+
+	// indexEntrySize is the position, timestamp, and room hash of a record
+	indexEntrySize = 24
+
+	logExt   = ".log"
+	indexExt = ".idx"
+)
+
+var errCorrupt = errors.New("corrupt record")
+
+type indexEntry struct {
+	pos  int64
+	ts   int64
+	room uint64
+}
+
+// segment is a single log file of events, and its index. Records in a segment have consecutive offsets, starting at base
+type segment struct {
+	base  uint64
+	log   *os.File
+	index *os.File
+	size  int64
+
+	// entries is only appended to, so a copy of the slice is safe to read without the store's lock
+	entries []indexEntry
+	minTS   int64
+	maxTS   int64
+}
+
+func segmentName(dir string, base uint64, ext string) string {
+	return filepath.Join(dir, fmt.Sprintf("%020d%s", base, ext))
+}
+
+func roomHash(roomID string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(strings.ToUpper(roomID)))
+	return h.Sum64()
+}
+
+// openSegment opens (or creates) the segment starting at base, and makes sure its index covers every record in it.
+// If last is true, a partially written record at the end of the log (e.g. from a crash) is truncated.
+func openSegment(dir string, base uint64, last bool) (*segment, error) {
+	lf, err := os.OpenFile(segmentName(dir, base, logExt), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open segment: %s", err)
+	}
+
+	idx, err := os.OpenFile(segmentName(dir, base, indexExt), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		lf.Close()
+		return nil, fmt.Errorf("unable to open segment index: %s", err)
+	}
+
+	s := &segment{base: base, log: lf, index: idx}
+	if err := s.load(last); err != nil {
+		s.close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// load reads the index, and indexes any records after the last one it covers
+func (s *segment) load(last bool) error {
+	data, err := ioutil.ReadAll(s.index)
+	if err != nil {
+		return fmt.Errorf("unable to read segment index: %s", err)
+	}
+
+	// drop a partially written entry
+	data = data[:len(data)-len(data)%indexEntrySize]
+
+	for i := 0; i < len(data); i += indexEntrySize {
+		s.addEntry(indexEntry{
+			pos:  int64(binary.BigEndian.Uint64(data[i:])),
+			ts:   int64(binary.BigEndian.Uint64(data[i+8:])),
+			room: binary.BigEndian.Uint64(data[i+16:]),
+		})
+	}
+
+	info, err := s.log.Stat()
+	if err != nil {
+		return fmt.Errorf("unable to stat segment: %s", err)
+	}
+
+	s.size = info.Size()
+
+	// find where the index stops covering the log
+	var pos int64
+	for len(s.entries) > 0 {
+		entry := s.entries[len(s.entries)-1]
+
+		_, n, err := s.read(entry.pos, s.size)
+		if err == nil {
+			pos = entry.pos + int64(n)
+			break
+		}
+
+		// the index points at a record that never made it to disk
+		s.entries = s.entries[:len(s.entries)-1]
+	}
+
+	if err := s.index.Truncate(int64(len(s.entries) * indexEntrySize)); err != nil {
+		return fmt.Errorf("unable to truncate segment index: %s", err)
+	}
+
+	if _, err := s.index.Seek(0, io.SeekEnd); err != nil {
+		return fmt.Errorf("unable to seek segment index: %s", err)
+	}
+
+	// index the records that aren't in the index yet
+	for pos < s.size {
+		e, n, err := s.read(pos, s.size)
+		if err != nil {
+			if !last {
+				log.L.Warnf("[store] Segment %v is corrupt at %v, ignoring the rest of it: %s", s.base, pos, err)
+				break
+			}
+
+			log.L.Warnf("[store] Truncating partial record at %v in segment %v: %s", pos, s.base, err)
+			if err := s.log.Truncate(pos); err != nil {
+				return fmt.Errorf("unable to truncate segment: %s", err)
+			}
+
+			s.size = pos
+			break
+		}
+
+		if err := s.writeEntry(newEntry(pos, e)); err != nil {
+			return err
+		}
+
+		pos += int64(n)
+	}
+
+	if _, err := s.log.Seek(s.size, io.SeekStart); err != nil {
+		return fmt.Errorf("unable to seek segment: %s", err)
+	}
+
+	return nil
+}
+
+func newEntry(pos int64, e events.Event) indexEntry {
+	return indexEntry{
+		pos:  pos,
+		ts:   e.Timestamp.UnixNano(),
+		room: roomHash(e.AffectedRoom.RoomID),
+	}
+}
+
+func (s *segment) addEntry(entry indexEntry) {
+	if len(s.entries) == 0 || entry.ts < s.minTS {
+		s.minTS = entry.ts
+	}
+
+	if len(s.entries) == 0 || entry.ts > s.maxTS {
+		s.maxTS = entry.ts
+	}
+
+	s.entries = append(s.entries, entry)
+}
+
+func (s *segment) writeEntry(entry indexEntry) error {
+	buf := make([]byte, indexEntrySize)
+	binary.BigEndian.PutUint64(buf, uint64(entry.pos))
+	binary.BigEndian.PutUint64(buf[8:], uint64(entry.ts))
+	binary.BigEndian.PutUint64(buf[16:], entry.room)
+
+	if _, err := s.index.Write(buf); err != nil {
+		// don't leave a partial entry behind
+		end := int64(len(s.entries) * indexEntrySize)
+		s.index.Truncate(end)
+		s.index.Seek(end, io.SeekStart)
+		return fmt.Errorf("unable to write segment index: %s", err)
+	}
+
+	s.addEntry(entry)
+	return nil
+}
+
+// append writes e to the end of the segment
+func (s *segment) append(e events.Event) error {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("unable to marshal event: %s", err)
+	}
+
+	buf := make([]byte, recordHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(buf, uint32(len(payload)))
+	binary.BigEndian.PutUint32(buf[4:], crc32.ChecksumIEEE(payload))
+	copy(buf[recordHeaderSize:], payload)
+
+	pos := s.size
+	if _, err := s.log.Write(buf); err != nil {
+		// don't leave a partial record behind
+		s.log.Truncate(pos)
+		s.log.Seek(pos, io.SeekStart)
+		return fmt.Errorf("unable to write event: %s", err)
+	}
+
+	if err := s.writeEntry(newEntry(pos, e)); err != nil {
+		// an event that isn't in the index can't be read, so drop it from the log too
+		s.log.Truncate(pos)
+		s.log.Seek(pos, io.SeekStart)
+		return err
+	}
+
+	s.size += int64(len(buf))
+	return nil
+}
+
+// read reads the record at pos, returning its size on disk. size is how much of the log has been written
+func (s *segment) read(pos, size int64) (events.Event, int, error) {
+	var e events.Event
+
+	header := make([]byte, recordHeaderSize)
+	if _, err := s.log.ReadAt(header, pos); err != nil {
+		return e, 0, fmt.Errorf("%s: %s", errCorrupt, err)
+	}
+
+	length := binary.BigEndian.Uint32(header)
+	if int64(length) > size-pos-recordHeaderSize {
+		return e, 0, fmt.Errorf("%s: length %v is past the end of the segment", errCorrupt, length)
+	}
+
+	payload := make([]byte, length)
+	if _, err := s.log.ReadAt(payload, pos+recordHeaderSize); err != nil {
+		return e, 0, fmt.Errorf("%s: %s", errCorrupt, err)
+	}
+
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:]) {
+		return e, 0, fmt.Errorf("%s: checksum mismatch", errCorrupt)
+	}
+
+	if err := json.Unmarshal(payload, &e); err != nil {
+		return e, 0, fmt.Errorf("%s: %s", errCorrupt, err)
+	}
+
+	return e, recordHeaderSize + int(length), nil
+}
+
+func (s *segment) sync() error {
+	if err := s.log.Sync(); err != nil {
+		return err
+	}
+
+	return s.index.Sync()
+}
+
+func (s *segment) close() error {
+	s.index.Close()
+	return s.log.Close()
+}
+
+func (s *segment) remove(dir string) error {
+	s.close()
+
+	if err := os.Remove(segmentName(dir, s.base, logExt)); err != nil {
+		return err
+	}
+
+	return os.Remove(segmentName(dir, s.base, indexExt))
+}
@@ -0,0 +1,386 @@
+// Package store is a durable, append-only log of v2 events on local disk.
+//
+// Events are written to segment files, each with an index of when each event happened and which room it affected, so that events can be replayed from a point in time or for a single room.
+// Every event gets an offset, which increases by one for each event appended. A service forwarding events somewhere that might be unreachable can save the offset of the last event it forwarded, and replay everything after it once it can reach it again.
+package store
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/byuoitav/common/log"
+	"github.com/byuoitav/common/v2/events"
+)
+
+// DefaultMaxSegmentSize is how large a segment gets before a new one is started, unless WithMaxSegmentSize is used.
+const DefaultMaxSegmentSize = 64 * 1024 * 1024
+
+// ErrClosed is returned when the store has been closed.
+var ErrClosed = errors.New("event store is closed")
+
+// ErrStop can be returned from a replay function to stop replaying without an error.
+var ErrStop = errors.New("stop replaying")
+
+// Record is an event and its offset in the store.
+type Record struct {
+	Offset uint64       `json:"offset"`
+	Event  events.Event `json:"event"`
+}
+
+// Query selects which events to replay. Fields that aren't set don't limit anything.
+type Query struct {
+	// After only replays events with a larger offset
+	After uint64 `json:"after,omitempty"`
+
+	// From only replays events that happened at or after it
+	From time.Time `json:"from,omitempty"`
+
+	// Until only replays events that happened before it
+	Until time.Time `json:"until,omitempty"`
+
+	// RoomID only replays events that affected the room
+	RoomID string `json:"room-id,omitempty"`
+}
+
+// Option configures a Store.
+type Option func(*Store)
+
+// WithMaxSegmentSize sets how large a segment gets before a new one is started.
+func WithMaxSegmentSize(size int64) Option {
+	return func(s *Store) {
+		s.maxSegmentSize = size
+	}
+}
+
+// WithSync makes every append wait for the event to be synced to disk. It's much slower, but no events are lost if the device loses power.
+func WithSync(sync bool) Option {
+	return func(s *Store) {
+		s.syncEvery = sync
+	}
+}
+
+// WithRetention deletes segments whose events are all older than age, each time a new segment is started.
+func WithRetention(age time.Duration) Option {
+	return func(s *Store) {
+		s.retention = age
+	}
+}
+
+// Store is an event log in a directory.
+type Store struct {
+	dir            string
+	maxSegmentSize int64
+	syncEvery      bool
+	retention      time.Duration
+
+	// mu protects appending and the list of segments
+	mu       sync.RWMutex
+	segments []*segment
+	closed   bool
+
+	// removeMu keeps segments from being closed while they are being replayed
+	removeMu sync.RWMutex
+}
+
+// Open opens the store in dir, creating it if it doesn't exist. Anything left partially written by a crash is cleaned up.
+func Open(dir string, opts ...Option) (*Store, error) {
+	s := &Store{
+		dir:            dir,
+		maxSegmentSize: DefaultMaxSegmentSize,
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("unable to create event store: %s", err)
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*"+logExt))
+	if err != nil {
+		return nil, fmt.Errorf("unable to list segments: %s", err)
+	}
+
+	var bases []uint64
+	for _, path := range paths {
+		base, err := strconv.ParseUint(strings.TrimSuffix(filepath.Base(path), logExt), 10, 64)
+		if err != nil {
+			log.L.Warnf("[store] Ignoring unknown file %v in event store", path)
+			continue
+		}
+
+		bases = append(bases, base)
+	}
+
+	sort.Slice(bases, func(i, j int) bool { return bases[i] < bases[j] })
+
+	if len(bases) == 0 {
+		bases = append(bases, 1)
+	}
+
+	for i, base := range bases {
+		seg, err := openSegment(dir, base, i == len(bases)-1)
+		if err != nil {
+			s.closeSegments()
+			return nil, fmt.Errorf("unable to open segment %v: %s", base, err)
+		}
+
+		s.segments = append(s.segments, seg)
+	}
+
+	log.L.Infof("[store] Opened event store in %v with %v segments, last offset %v", dir, len(s.segments), s.last())
+	return s, nil
+}
+
+func (s *Store) active() *segment {
+	return s.segments[len(s.segments)-1]
+}
+
+// last returns the offset of the newest event. The caller must hold mu
+func (s *Store) last() uint64 {
+	active := s.active()
+	return active.base + uint64(len(active.entries)) - 1
+}
+
+// Last returns the offset of the newest event, or 0 if there aren't any.
+func (s *Store) Last() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.last()
+}
+
+// Append writes e to the end of the log, and returns its offset. If e doesn't have a timestamp, it's set to now.
+func (s *Store) Append(e events.Event) (uint64, error) {
+	if e.Timestamp.IsZero() {
+		e.Timestamp = time.Now()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return 0, ErrClosed
+	}
+
+	if s.active().size >= s.maxSegmentSize {
+		if err := s.roll(); err != nil {
+			return 0, err
+		}
+	}
+
+	active := s.active()
+	if err := active.append(e); err != nil {
+		return 0, err
+	}
+
+	if s.syncEvery {
+		if err := active.sync(); err != nil {
+			return 0, fmt.Errorf("unable to sync event: %s", err)
+		}
+	}
+
+	return s.last(), nil
+}
+
+// Publish appends e, logging any error. It can be passed anywhere a func(events.Event) is expected, e.g. to store everything on a bus.
+func (s *Store) Publish(e events.Event) {
+	if _, err := s.Append(e); err != nil {
+		log.L.Warnf("[store] Failed to store event %v: %s", e.Key, err)
+	}
+}
+
+// roll starts a new segment. The caller must hold mu
+func (s *Store) roll() error {
+	next := s.last() + 1
+
+	if err := s.active().sync(); err != nil {
+		return fmt.Errorf("unable to sync segment: %s", err)
+	}
+
+	seg, err := openSegment(s.dir, next, true)
+	if err != nil {
+		return fmt.Errorf("unable to start a new segment: %s", err)
+	}
+
+	s.segments = append(s.segments, seg)
+	log.L.Debugf("[store] Started segment %v", next)
+
+	if s.retention > 0 {
+		go func() {
+			if _, err := s.DeleteBefore(time.Now().Add(-s.retention)); err != nil {
+				log.L.Warnf("[store] Failed to delete old segments: %s", err)
+			}
+		}()
+	}
+
+	return nil
+}
+
+// Replay calls fn with each event that matches q, oldest offset first. Events appended while replaying may or may not be included.
+// If fn returns an error, replaying stops and the error is returned, unless it's ErrStop.
+// Segments can't be deleted while they are being replayed, so fn must not call Close or DeleteBefore; doing so deadlocks. Appending from fn is fine.
+func (s *Store) Replay(q Query, fn func(Record) error) error {
+	s.removeMu.RLock()
+	defer s.removeMu.RUnlock()
+
+	type snapshot struct {
+		seg     *segment
+		entries []indexEntry
+		size    int64
+		minTS   int64
+		maxTS   int64
+	}
+
+	s.mu.RLock()
+	if s.closed {
+		s.mu.RUnlock()
+		return ErrClosed
+	}
+
+	snapshots := make([]snapshot, 0, len(s.segments))
+	for _, seg := range s.segments {
+		snapshots = append(snapshots, snapshot{seg: seg, entries: seg.entries, size: seg.size, minTS: seg.minTS, maxTS: seg.maxTS})
+	}
+	s.mu.RUnlock()
+
+	var from, until int64
+	if !q.From.IsZero() {
+		from = q.From.UnixNano()
+	}
+
+	if !q.Until.IsZero() {
+		until = q.Until.UnixNano()
+	}
+
+	var room uint64
+	if len(q.RoomID) > 0 {
+		room = roomHash(q.RoomID)
+	}
+
+	for _, snap := range snapshots {
+		if len(snap.entries) == 0 || snap.seg.base+uint64(len(snap.entries))-1 <= q.After {
+			continue
+		}
+
+		if (from != 0 && snap.maxTS < from) || (until != 0 && snap.minTS >= until) {
+			continue
+		}
+
+		for i, entry := range snap.entries {
+			offset := snap.seg.base + uint64(i)
+
+			switch {
+			case offset <= q.After:
+				continue
+			case from != 0 && entry.ts < from:
+				continue
+			case until != 0 && entry.ts >= until:
+				continue
+			case room != 0 && entry.room != room:
+				continue
+			}
+
+			e, _, err := snap.seg.read(entry.pos, snap.size)
+			if err != nil {
+				return fmt.Errorf("unable to read event %v: %s", offset, err)
+			}
+
+			// different rooms can have the same hash
+			if room != 0 && !strings.EqualFold(e.AffectedRoom.RoomID, q.RoomID) {
+				continue
+			}
+
+			if err := fn(Record{Offset: offset, Event: e}); err != nil {
+				if err == ErrStop {
+					return nil
+				}
+
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// DeleteBefore deletes each segment whose events all happened before t, except the one being written to. It returns how many segments were deleted.
+func (s *Store) DeleteBefore(t time.Time) (int, error) {
+	s.removeMu.Lock()
+	defer s.removeMu.Unlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return 0, ErrClosed
+	}
+
+	cutoff := t.UnixNano()
+	deleted := 0
+
+	for len(s.segments) > 1 {
+		seg := s.segments[0]
+		if len(seg.entries) > 0 && seg.maxTS >= cutoff {
+			break
+		}
+
+		if err := seg.remove(s.dir); err != nil {
+			return deleted, fmt.Errorf("unable to delete segment %v: %s", seg.base, err)
+		}
+
+		s.segments = s.segments[1:]
+		deleted++
+	}
+
+	if deleted > 0 {
+		log.L.Infof("[store] Deleted %v old segments", deleted)
+	}
+
+	return deleted, nil
+}
+
+// Sync makes sure every event appended so far is on disk.
+func (s *Store) Sync() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+
+	return s.active().sync()
+}
+
+// Close syncs and closes the store. Replays that are running are finished first.
+func (s *Store) Close() error {
+	s.removeMu.Lock()
+	defer s.removeMu.Unlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+
+	s.closed = true
+
+	err := s.active().sync()
+	s.closeSegments()
+	return err
+}
+
+func (s *Store) closeSegments() {
+	for _, seg := range s.segments {
+		seg.close()
+	}
+}
@@ -0,0 +1,145 @@
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/byuoitav/common/v2/events"
+)
+
+func replay(t *testing.T, s *Store, q Query) []Record {
+	var records []Record
+
+	err := s.Replay(q, func(r Record) error {
+		records = append(records, r)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to replay: %s", err)
+	}
+
+	return records
+}
+
+func TestStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "events")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := Open(dir, WithMaxSegmentSize(512))
+	if err != nil {
+		t.Fatalf("failed to open store: %s", err)
+	}
+
+	start := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 20; i++ {
+		room := "ITB-1101"
+		if i%2 == 1 {
+			room = "ITB-1108"
+		}
+
+		offset, err := s.Append(events.Event{
+			Timestamp:    start.Add(time.Duration(i) * time.Minute),
+			Key:          "power",
+			AffectedRoom: events.GenerateBasicRoomInfo(room),
+		})
+		if err != nil || offset != uint64(i+1) {
+			t.Fatalf("expected offset %v, got %v (err: %v)", i+1, offset, err)
+		}
+	}
+
+	if len(s.segments) < 2 {
+		t.Fatalf("expected more than one segment, got %v", len(s.segments))
+	}
+
+	records := replay(t, s, Query{RoomID: "itb-1108", From: start.Add(10 * time.Minute)})
+	if len(records) != 5 || records[0].Offset != 12 || records[0].Event.AffectedRoom.RoomID != "ITB-1108" {
+		t.Fatalf("expected 5 events in ITB-1108, got %+v", records)
+	}
+
+	if records := replay(t, s, Query{After: 18}); len(records) != 2 {
+		t.Fatalf("expected 2 events after 18, got %v", len(records))
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatalf("failed to close store: %s", err)
+	}
+
+	// simulate a crash in the middle of a write
+	segs, _ := filepath.Glob(filepath.Join(dir, "*"+logExt))
+	f, err := os.OpenFile(segs[len(segs)-1], os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("failed to open segment: %s", err)
+	}
+
+	f.Write([]byte{0, 0, 1, 0, 1, 2})
+	f.Close()
+
+	s, err = Open(dir, WithMaxSegmentSize(512))
+	if err != nil {
+		t.Fatalf("failed to reopen store: %s", err)
+	}
+	defer s.Close()
+
+	if s.Last() != 20 {
+		t.Fatalf("expected the last offset to be 20, got %v", s.Last())
+	}
+
+	if offset, err := s.Append(events.Event{Key: "input"}); err != nil || offset != 21 {
+		t.Fatalf("expected offset 21, got %v (err: %v)", offset, err)
+	}
+
+	if records := replay(t, s, Query{Until: start.Add(3 * time.Minute)}); len(records) != 3 {
+		t.Fatalf("expected 3 events before 00:03, got %v", len(records))
+	}
+
+	deleted, err := s.DeleteBefore(start.Add(5 * time.Minute))
+	if err != nil || deleted == 0 {
+		t.Fatalf("expected old segments to be deleted, got %v (err: %v)", deleted, err)
+	}
+
+	if records := replay(t, s, Query{}); records[0].Offset == 1 {
+		t.Fatalf("expected the first events to be deleted")
+	}
+}
+
+func TestStoreFailedIndexWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "events")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := Open(dir)
+	if err != nil {
+		t.Fatalf("failed to open store: %s", err)
+	}
+	defer s.Close()
+
+	if _, err := s.Append(events.Event{Key: "power"}); err != nil {
+		t.Fatalf("failed to append: %s", err)
+	}
+
+	// the index can't be written, so the event can't be appended
+	seg := s.active()
+	seg.index.Close()
+
+	if _, err := s.Append(events.Event{Key: "input"}); err == nil {
+		t.Fatalf("expected the append to fail")
+	}
+
+	// and it isn't left in the log
+	info, err := seg.log.Stat()
+	if err != nil {
+		t.Fatalf("failed to stat log: %s", err)
+	}
+
+	if info.Size() != seg.size || s.Last() != 1 {
+		t.Fatalf("expected the log to only have the first event, got %v bytes (expected %v)", info.Size(), seg.size)
+	}
+}
@@ -0,0 +1,71 @@
+package usage
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// collector exports an Aggregator's snapshots as prometheus metrics each time it's scraped
+type collector struct {
+	a *Aggregator
+
+	roomPowerOn        *prometheus.Desc
+	roomInput          *prometheus.Desc
+	roomInteractions   *prometheus.Desc
+	roomSinceLast      *prometheus.Desc
+	devicePowerOn      *prometheus.Desc
+	deviceInput        *prometheus.Desc
+	deviceInteractions *prometheus.Desc
+}
+
+// Register registers prometheus metrics for every room and device with reg, named namespace_usage_*.
+// Interactions are exported as counters, so interactions per hour is e.g. increase(namespace_usage_room_interactions_total[1h]).
+func (a *Aggregator) Register(namespace string, reg prometheus.Registerer) error {
+	name := func(name string) string {
+		return prometheus.BuildFQName(namespace, "usage", name)
+	}
+
+	return reg.Register(&collector{
+		a:                  a,
+		roomPowerOn:        prometheus.NewDesc(name("room_power_on_seconds_total"), "How long at least one device in the room has been on.", []string{"room"}, nil),
+		roomInput:          prometheus.NewDesc(name("room_input_seconds_total"), "How long each input has been used in the room, added up across devices.", []string{"room", "input"}, nil),
+		roomInteractions:   prometheus.NewDesc(name("room_interactions_total"), "Number of user interactions in the room.", []string{"room"}, nil),
+		roomSinceLast:      prometheus.NewDesc(name("room_seconds_since_last_interaction"), "How long it has been since the last user interaction in the room.", []string{"room"}, nil),
+		devicePowerOn:      prometheus.NewDesc(name("device_power_on_seconds_total"), "How long the device has been on.", []string{"room", "device"}, nil),
+		deviceInput:        prometheus.NewDesc(name("device_input_seconds_total"), "How long each input has been selected on the device.", []string{"room", "device", "input"}, nil),
+		deviceInteractions: prometheus.NewDesc(name("device_interactions_total"), "Number of user interactions with the device.", []string{"room", "device"}, nil),
+	})
+}
+
+func (c *collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.roomPowerOn
+	ch <- c.roomInput
+	ch <- c.roomInteractions
+	ch <- c.roomSinceLast
+	ch <- c.devicePowerOn
+	ch <- c.deviceInput
+	ch <- c.deviceInteractions
+}
+
+func (c *collector) Collect(ch chan<- prometheus.Metric) {
+	for _, room := range c.a.Rooms() {
+		ch <- prometheus.MustNewConstMetric(c.roomPowerOn, prometheus.CounterValue, room.PowerOnTime.Seconds(), room.RoomID)
+		ch <- prometheus.MustNewConstMetric(c.roomInteractions, prometheus.CounterValue, float64(room.Interactions.Total), room.RoomID)
+
+		for input, d := range room.InputTime {
+			ch <- prometheus.MustNewConstMetric(c.roomInput, prometheus.CounterValue, d.Seconds(), room.RoomID, input)
+		}
+
+		if !room.Interactions.Last.IsZero() {
+			ch <- prometheus.MustNewConstMetric(c.roomSinceLast, prometheus.GaugeValue, room.Interactions.SinceLast.Seconds(), room.RoomID)
+		}
+
+		for _, dev := range room.Devices {
+			ch <- prometheus.MustNewConstMetric(c.devicePowerOn, prometheus.CounterValue, dev.PowerOnTime.Seconds(), room.RoomID, dev.DeviceID)
+			ch <- prometheus.MustNewConstMetric(c.deviceInteractions, prometheus.CounterValue, float64(dev.Interactions.Total), room.RoomID, dev.DeviceID)
+
+			for input, d := range dev.InputTime {
+				ch <- prometheus.MustNewConstMetric(c.deviceInput, prometheus.CounterValue, d.Seconds(), room.RoomID, dev.DeviceID, input)
+			}
+		}
+	}
+}
@@ -0,0 +1,439 @@
+// Package usage aggregates v2 events into usage statistics for each room and device, like how long displays were on, how long each input was used, and how often people interacted with the room.
+//
+// Durations are measured between event timestamps, so an Aggregator can be fed live events or replayed from a store to build a report for a past time period. Reports for a past time period should use RoomsAt, RoomAt, and DeviceAt with the end of the period, since the other snapshots count up to now.
+package usage
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/byuoitav/common/log"
+	"github.com/byuoitav/common/v2/events"
+)
+
+// Event keys that change a device's state
+const (
+	PowerKey = "power"
+	InputKey = "input"
+)
+
+// DefaultHistory is how many hours of interaction counts are kept, unless WithHistory is used.
+const DefaultHistory = 7 * 24
+
+// Option configures an Aggregator.
+type Option func(*Aggregator)
+
+// WithHistory sets how many hours of interaction counts are kept.
+func WithHistory(hours int) Option {
+	return func(a *Aggregator) {
+		a.history = hours
+	}
+}
+
+// HourCount is how many user interactions happened during an hour.
+type HourCount struct {
+	Hour  time.Time `json:"hour"`
+	Count int       `json:"count"`
+}
+
+// Interactions is how often people have interacted with a room or device. Any event tagged user-generated counts as an interaction.
+type Interactions struct {
+	Total uint64 `json:"total"`
+
+	// PerHour is the number of interactions in each hour that had any, oldest first
+	PerHour []HourCount `json:"per-hour,omitempty"`
+
+	// Last is when the last interaction happened, and SinceLast is how long ago that was. Both are zero if there haven't been any
+	Last      time.Time     `json:"last,omitempty"`
+	SinceLast time.Duration `json:"since-last,omitempty"`
+}
+
+// DeviceSnapshot is a device's usage up to when the snapshot was taken.
+type DeviceSnapshot struct {
+	DeviceID string `json:"device-id"`
+	RoomID   string `json:"room-id"`
+
+	// Power and Input are the device's current state
+	Power string `json:"power,omitempty"`
+	Input string `json:"input,omitempty"`
+
+	// PowerOnTime is how long the device has been on
+	PowerOnTime time.Duration `json:"power-on-time"`
+
+	// InputTime is how long each input has been selected while the device wasn't off. Devices that don't report power (like switchers) count the whole time
+	InputTime map[string]time.Duration `json:"input-time,omitempty"`
+
+	Interactions Interactions `json:"interactions"`
+}
+
+// RoomSnapshot is a room's usage up to when the snapshot was taken.
+type RoomSnapshot struct {
+	RoomID string `json:"room-id"`
+
+	// PowerOnTime is how long at least one device in the room has been on
+	PowerOnTime time.Duration `json:"power-on-time"`
+
+	// InputTime is how long each input has been selected, added up across every device in the room
+	InputTime map[string]time.Duration `json:"input-time,omitempty"`
+
+	Interactions Interactions `json:"interactions"`
+
+	Devices []DeviceSnapshot `json:"devices"`
+}
+
+// timer adds up how long something has spent in each state
+type timer struct {
+	state  string
+	since  time.Time
+	totals map[string]time.Duration
+}
+
+// set changes the state at time at. Times before the last change are treated as happening at the last change
+func (t *timer) set(state string, at time.Time) {
+	if at.Before(t.since) {
+		at = t.since
+	}
+
+	if !t.since.IsZero() {
+		if t.totals == nil {
+			t.totals = make(map[string]time.Duration)
+		}
+
+		t.totals[t.state] += at.Sub(t.since)
+	}
+
+	t.state = state
+	t.since = at
+}
+
+// durations returns how long has been spent in each state, counting the current state up to now
+func (t *timer) durations(now time.Time) map[string]time.Duration {
+	durations := make(map[string]time.Duration, len(t.totals)+1)
+	for state, d := range t.totals {
+		durations[state] = d
+	}
+
+	if !t.since.IsZero() && now.After(t.since) {
+		durations[t.state] += now.Sub(t.since)
+	}
+
+	return durations
+}
+
+// counter counts interactions by the hour
+type counter struct {
+	total uint64
+	hours map[time.Time]int
+	last  time.Time
+}
+
+func (c *counter) add(at time.Time, history int) {
+	if c.hours == nil {
+		c.hours = make(map[time.Time]int)
+	}
+
+	c.total++
+	c.hours[at.Truncate(time.Hour)]++
+
+	if at.After(c.last) {
+		c.last = at
+	}
+
+	c.prune(c.last, history)
+}
+
+// prune removes the hours that are more than history hours before now
+func (c *counter) prune(now time.Time, history int) {
+	cutoff := now.Truncate(time.Hour).Add(-time.Duration(history) * time.Hour)
+
+	for hour := range c.hours {
+		if !hour.After(cutoff) {
+			delete(c.hours, hour)
+		}
+	}
+}
+
+// snapshot counts the interactions up to now. Hours that are more than history hours before now are left out, but not pruned, so that an earlier snapshot can still be taken
+func (c *counter) snapshot(now time.Time, history int) Interactions {
+	i := Interactions{
+		Total: c.total,
+		Last:  c.last,
+	}
+
+	cutoff := now.Truncate(time.Hour).Add(-time.Duration(history) * time.Hour)
+	for hour, count := range c.hours {
+		if hour.After(cutoff) && !hour.After(now) {
+			i.PerHour = append(i.PerHour, HourCount{Hour: hour, Count: count})
+		}
+	}
+
+	sort.Slice(i.PerHour, func(a, b int) bool { return i.PerHour[a].Hour.Before(i.PerHour[b].Hour) })
+
+	if !c.last.IsZero() && now.After(c.last) {
+		i.SinceLast = now.Sub(c.last)
+	}
+
+	return i
+}
+
+type device struct {
+	id   string
+	room *room
+
+	power   string
+	input   string
+	updated time.Time
+
+	powerTime    timer
+	inputTime    timer
+	interactions counter
+}
+
+// inputState is the input that is being used, which is none if the device is off
+func (d *device) inputState() string {
+	if len(d.power) > 0 && d.power != "on" {
+		return ""
+	}
+
+	return d.input
+}
+
+type room struct {
+	id      string
+	devices map[string]*device
+
+	powerTime    timer
+	interactions counter
+}
+
+// powerState is on if any device in the room is on
+func (r *room) powerState() string {
+	for _, d := range r.devices {
+		if d.power == "on" {
+			return "on"
+		}
+	}
+
+	return "off"
+}
+
+// Aggregator keeps usage statistics for each room and device from the events it's given.
+type Aggregator struct {
+	history int
+	now     func() time.Time
+
+	mu      sync.Mutex
+	rooms   map[string]*room
+	devices map[string]*device
+}
+
+// New creates an empty aggregator.
+func New(opts ...Option) *Aggregator {
+	a := &Aggregator{
+		history: DefaultHistory,
+		now:     time.Now,
+		rooms:   make(map[string]*room),
+		devices: make(map[string]*device),
+	}
+
+	for _, opt := range opts {
+		opt(a)
+	}
+
+	return a
+}
+
+// Publish adds e to the statistics. If e doesn't have a timestamp, it's treated as happening now. It can be used anywhere a func(events.Event) is expected, e.g. with a bus subscription or a store replay.
+// A power or input change that happened before the last one applied to the same device is ignored, although it still counts as an interaction.
+func (a *Aggregator) Publish(e events.Event) {
+	at := e.Timestamp
+	if at.IsZero() {
+		at = a.now()
+	}
+
+	roomID, deviceID := ids(e)
+	if len(roomID) == 0 && len(deviceID) == 0 {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	r := a.room(roomID)
+
+	var d *device
+	if len(deviceID) > 0 {
+		d = a.device(r, deviceID)
+	}
+
+	if events.ContainsAnyTags(e, events.UserGenerated) {
+		r.interactions.add(at, a.history)
+
+		if d != nil {
+			d.interactions.add(at, a.history)
+		}
+	}
+
+	if d == nil || (e.Key != PowerKey && e.Key != InputKey) {
+		return
+	}
+
+	if at.Before(d.updated) {
+		log.L.Debugf("[usage] Ignoring %v change on %v from %v: it's older than the last change", e.Key, d.id, at)
+		return
+	}
+
+	d.updated = at
+
+	switch e.Key {
+	case PowerKey:
+		d.power = strings.ToLower(e.Value)
+		d.powerTime.set(d.power, at)
+		r.powerTime.set(r.powerState(), at)
+	case InputKey:
+		d.input = e.Value
+	}
+
+	d.inputTime.set(d.inputState(), at)
+}
+
+// ids finds the room and device that e affected
+func ids(e events.Event) (string, string) {
+	deviceID := e.TargetDevice.DeviceID
+
+	roomID := e.AffectedRoom.RoomID
+	if len(roomID) == 0 {
+		roomID = e.TargetDevice.RoomID
+	}
+
+	if len(roomID) == 0 && len(deviceID) > 0 {
+		roomID = events.GenerateBasicDeviceInfo(deviceID).RoomID
+	}
+
+	return roomID, deviceID
+}
+
+// room returns the room with id, creating it if it doesn't exist. The caller must hold mu
+func (a *Aggregator) room(id string) *room {
+	r, ok := a.rooms[id]
+	if !ok {
+		r = &room{
+			id:      id,
+			devices: make(map[string]*device),
+		}
+
+		a.rooms[id] = r
+	}
+
+	return r
+}
+
+// device returns the device with id, creating it in r if it doesn't exist. The caller must hold mu
+func (a *Aggregator) device(r *room, id string) *device {
+	d, ok := a.devices[id]
+	if !ok {
+		d = &device{id: id, room: r}
+
+		a.devices[id] = d
+		r.devices[id] = d
+	}
+
+	return d
+}
+
+// Rooms returns a snapshot of every room up to now, sorted by ID.
+func (a *Aggregator) Rooms() []RoomSnapshot {
+	return a.RoomsAt(a.now())
+}
+
+// RoomsAt returns a snapshot of every room up to end, sorted by ID. The current state of each device is counted up to end, so end shouldn't be before the last event.
+func (a *Aggregator) RoomsAt(end time.Time) []RoomSnapshot {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	snapshots := make([]RoomSnapshot, 0, len(a.rooms))
+	for _, r := range a.rooms {
+		snapshots = append(snapshots, a.roomSnapshot(r, end))
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].RoomID < snapshots[j].RoomID })
+	return snapshots
+}
+
+// Room returns a snapshot of the room with id up to now. The bool is false if no events have affected it.
+func (a *Aggregator) Room(id string) (RoomSnapshot, bool) {
+	return a.RoomAt(id, a.now())
+}
+
+// RoomAt returns a snapshot of the room with id up to end, like RoomsAt. The bool is false if no events have affected it.
+func (a *Aggregator) RoomAt(id string, end time.Time) (RoomSnapshot, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	r, ok := a.rooms[id]
+	if !ok {
+		return RoomSnapshot{}, false
+	}
+
+	return a.roomSnapshot(r, end), true
+}
+
+// Device returns a snapshot of the device with id up to now. The bool is false if no events have affected it.
+func (a *Aggregator) Device(id string) (DeviceSnapshot, bool) {
+	return a.DeviceAt(id, a.now())
+}
+
+// DeviceAt returns a snapshot of the device with id up to end, like RoomsAt. The bool is false if no events have affected it.
+func (a *Aggregator) DeviceAt(id string, end time.Time) (DeviceSnapshot, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	d, ok := a.devices[id]
+	if !ok {
+		return DeviceSnapshot{}, false
+	}
+
+	return a.deviceSnapshot(d, end), true
+}
+
+// roomSnapshot is a snapshot of r. The caller must hold mu
+func (a *Aggregator) roomSnapshot(r *room, now time.Time) RoomSnapshot {
+	snapshot := RoomSnapshot{
+		RoomID:       r.id,
+		PowerOnTime:  r.powerTime.durations(now)["on"],
+		InputTime:    make(map[string]time.Duration),
+		Interactions: r.interactions.snapshot(now, a.history),
+	}
+
+	for _, d := range r.devices {
+		ds := a.deviceSnapshot(d, now)
+		for input, dur := range ds.InputTime {
+			snapshot.InputTime[input] += dur
+		}
+
+		snapshot.Devices = append(snapshot.Devices, ds)
+	}
+
+	sort.Slice(snapshot.Devices, func(i, j int) bool { return snapshot.Devices[i].DeviceID < snapshot.Devices[j].DeviceID })
+	return snapshot
+}
+
+// deviceSnapshot is a snapshot of d. The caller must hold mu
+func (a *Aggregator) deviceSnapshot(d *device, now time.Time) DeviceSnapshot {
+	snapshot := DeviceSnapshot{
+		DeviceID:     d.id,
+		RoomID:       d.room.id,
+		Power:        d.power,
+		Input:        d.input,
+		PowerOnTime:  d.powerTime.durations(now)["on"],
+		InputTime:    d.inputTime.durations(now),
+		Interactions: d.interactions.snapshot(now, a.history),
+	}
+
+	// time spent off isn't time on an input
+	delete(snapshot.InputTime, "")
+
+	return snapshot
+}
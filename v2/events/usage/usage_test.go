@@ -0,0 +1,143 @@
+package usage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/byuoitav/common/v2/events"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestAggregator(t *testing.T) {
+	start := time.Date(2019, 1, 1, 8, 0, 0, 0, time.UTC)
+	at := func(minutes int) time.Time {
+		return start.Add(time.Duration(minutes) * time.Minute)
+	}
+
+	a := New()
+	a.now = func() time.Time { return at(120) }
+
+	event := func(minutes int, device, key, value string, tags ...string) {
+		a.Publish(events.Event{
+			Timestamp:    at(minutes),
+			TargetDevice: events.GenerateBasicDeviceInfo(device),
+			Key:          key,
+			Value:        value,
+			EventTags:    tags,
+		})
+	}
+
+	event(0, "ITB-1101-D1", PowerKey, "on", events.UserGenerated)
+	event(0, "ITB-1101-D1", InputKey, "HDMI1")
+	event(30, "ITB-1101-D2", PowerKey, "on", events.UserGenerated)
+	event(45, "ITB-1101-D1", InputKey, "HDMI2", events.UserGenerated)
+	event(60, "ITB-1101-D1", PowerKey, "standby", events.UserGenerated)
+	event(90, "ITB-1101-D2", PowerKey, "standby", events.UserGenerated)
+
+	// older than the last change, so it doesn't turn the display back on
+	event(50, "ITB-1101-D1", PowerKey, "on")
+
+	d1, ok := a.Device("ITB-1101-D1")
+	if !ok {
+		t.Fatalf("expected ITB-1101-D1 to have a snapshot")
+	}
+
+	if d1.PowerOnTime != time.Hour || d1.InputTime["HDMI1"] != 45*time.Minute || d1.InputTime["HDMI2"] != 15*time.Minute || d1.Power != "standby" {
+		t.Fatalf("unexpected usage for ITB-1101-D1: %+v", d1)
+	}
+
+	room, ok := a.Room("ITB-1101")
+	if !ok {
+		t.Fatalf("expected ITB-1101 to have a snapshot")
+	}
+
+	if room.PowerOnTime != 90*time.Minute || len(room.Devices) != 2 || room.InputTime["HDMI1"] != 45*time.Minute {
+		t.Fatalf("unexpected usage for ITB-1101: %+v", room)
+	}
+
+	i := room.Interactions
+	if i.Total != 5 || len(i.PerHour) != 2 || i.PerHour[0].Count != 3 || i.PerHour[1].Count != 2 || i.SinceLast != 30*time.Minute {
+		t.Fatalf("unexpected interactions for ITB-1101: %+v", i)
+	}
+
+	// only the last day of interactions is kept
+	a.history = 24
+	a.now = func() time.Time { return at(24*60 + 30) }
+
+	if room, _ := a.Room("ITB-1101"); len(room.Interactions.PerHour) != 1 || room.Interactions.Total != 5 {
+		t.Fatalf("expected old interactions to be pruned, got %+v", room.Interactions)
+	}
+}
+
+func TestAggregatorReplay(t *testing.T) {
+	start := time.Date(2019, 1, 1, 8, 0, 0, 0, time.UTC)
+	end := start.Add(8 * time.Hour)
+
+	// replaying a past day's events, so now is long after they happened
+	a := New()
+	for _, e := range []events.Event{
+		{Timestamp: start, Key: PowerKey, Value: "on", EventTags: []string{events.UserGenerated}},
+		{Timestamp: start, Key: InputKey, Value: "HDMI1"},
+		{Timestamp: start.Add(2 * time.Hour), Key: InputKey, Value: "HDMI2", EventTags: []string{events.UserGenerated}},
+	} {
+		e.TargetDevice = events.GenerateBasicDeviceInfo("ITB-1101-D1")
+		a.Publish(e)
+	}
+
+	// the display was left on, so it counts as on until the end of the day
+	d1, ok := a.DeviceAt("ITB-1101-D1", end)
+	if !ok {
+		t.Fatalf("expected ITB-1101-D1 to have a snapshot")
+	}
+
+	if d1.PowerOnTime != 8*time.Hour || d1.InputTime["HDMI1"] != 2*time.Hour || d1.InputTime["HDMI2"] != 6*time.Hour {
+		t.Fatalf("unexpected usage for ITB-1101-D1: %+v", d1)
+	}
+
+	rooms := a.RoomsAt(end)
+	if len(rooms) != 1 || rooms[0].PowerOnTime != 8*time.Hour || rooms[0].Interactions.SinceLast != 6*time.Hour || len(rooms[0].Interactions.PerHour) != 2 {
+		t.Fatalf("unexpected usage for ITB-1101: %+v", rooms)
+	}
+
+	// a snapshot up to now counts every hour since then, and the interactions are too old to be counted by the hour
+	if room, _ := a.Room("ITB-1101"); room.PowerOnTime <= 8*time.Hour || len(room.Interactions.PerHour) != 0 {
+		t.Fatalf("expected the room to be on until now, got %+v", room)
+	}
+
+	// which doesn't change earlier snapshots
+	if room, _ := a.RoomAt("ITB-1101", end); len(room.Interactions.PerHour) != 2 {
+		t.Fatalf("expected both hours of interactions, got %+v", room.Interactions)
+	}
+}
+
+func TestAggregatorMetrics(t *testing.T) {
+	a := New()
+	a.Publish(events.Event{
+		Timestamp:    time.Now().Add(-time.Minute),
+		TargetDevice: events.GenerateBasicDeviceInfo("ITB-1101-D1"),
+		Key:          PowerKey,
+		Value:        "on",
+		EventTags:    []string{events.UserGenerated},
+	})
+
+	reg := prometheus.NewRegistry()
+	if err := a.Register("av", reg); err != nil {
+		t.Fatalf("failed to register metrics: %s", err)
+	}
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("failed to gather metrics: %s", err)
+	}
+
+	found := map[string]float64{}
+	for _, f := range families {
+		for _, m := range f.GetMetric() {
+			found[f.GetName()] = m.GetCounter().GetValue() + m.GetGauge().GetValue()
+		}
+	}
+
+	if found["av_usage_room_interactions_total"] != 1 || found["av_usage_device_power_on_seconds_total"] < 60 || found["av_usage_room_seconds_since_last_interaction"] < 60 {
+		t.Fatalf("unexpected metrics: %v", found)
+	}
+}
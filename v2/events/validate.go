@@ -0,0 +1,220 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// KnownTags is every event tag declared in this package.
+var KnownTags = []string{
+	Heartbeat, CoreState, DetailState, Error, UserGenerated, AutoGenerated, StartUp, RoomDivide, Metrics, Internal, RoomSystem, Alert,
+	Computer, Mstatus, Via, HardwareInfo, ActiveSignal, Support, UICommunication, UIEvent, CherryUI, BlueberryUI,
+}
+
+// DefaultMaxDataSize is the largest an event's Data can be (as JSON) with the default validator.
+const DefaultMaxDataSize = 64 * 1024
+
+// DefaultValidator is used by Validate and Normalize.
+var DefaultValidator = Validator{MaxDataSize: DefaultMaxDataSize}
+
+// ValidationError lists every problem found with an event.
+type ValidationError struct {
+	Problems []string `json:"problems"`
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid event: %s", strings.Join(e.Problems, "; "))
+}
+
+// Validator checks events, and fixes what it can.
+type Validator struct {
+	// Strict rejects tags that aren't in KnownTags or AllowedTags
+	Strict bool
+
+	// AllowedTags are tags allowed in strict mode, in addition to KnownTags
+	AllowedTags []string
+
+	// MaxDataSize is the largest Data can be, as JSON. If <= 0, it isn't limited
+	MaxDataSize int
+
+	// GeneratingSystem fills in events without one. If it's empty, the SYSTEM_ID environment variable is used
+	GeneratingSystem string
+}
+
+// Validate checks e with the default validator. See Validator.Validate.
+func Validate(e Event) error {
+	return DefaultValidator.Validate(e)
+}
+
+// Normalize fixes e with the default validator. See Validator.Normalize.
+func Normalize(e Event) (Event, error) {
+	return DefaultValidator.Normalize(e)
+}
+
+// Validate returns a *ValidationError listing every problem with e, including the ones Normalize would fix.
+func (v Validator) Validate(e Event) error {
+	_, problems := v.check(e, false)
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+
+	return nil
+}
+
+// Normalize returns a copy of e with its IDs uppercased, its target device and affected room filled in from each other, its timestamp and generating system filled in if they're missing, and duplicate tags removed.
+// Problems it can't fix (like a target device that isn't in the affected room) are returned in a *ValidationError, along with the normalized event.
+func (v Validator) Normalize(e Event) (Event, error) {
+	e, problems := v.check(e, true)
+	if len(problems) > 0 {
+		return e, &ValidationError{Problems: problems}
+	}
+
+	return e, nil
+}
+
+// check finds the problems with e, fixing the ones it can if fix is true
+func (v Validator) check(e Event, fix bool) (Event, []string) {
+	var problems []string
+	problem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	fixable := func(format string, args ...interface{}) {
+		if !fix {
+			problem(format, args...)
+		}
+	}
+
+	if len(strings.TrimSpace(e.Key)) == 0 {
+		problem("key is empty")
+	}
+
+	if e.Timestamp.IsZero() {
+		fixable("timestamp is missing")
+		e.Timestamp = time.Now()
+	}
+
+	if len(e.GeneratingSystem) == 0 {
+		system := v.GeneratingSystem
+		if len(system) == 0 {
+			system = os.Getenv("SYSTEM_ID")
+		}
+
+		if len(system) == 0 {
+			problem("generating system is empty")
+		} else {
+			fixable("generating system is empty")
+		}
+
+		e.GeneratingSystem = system
+	}
+
+	// ids
+	device := e.TargetDevice
+	if len(device.DeviceID) > 0 {
+		device = GenerateBasicDeviceInfo(device.DeviceID)
+		if len(device.RoomID) == 0 {
+			// the id isn't in the BLDG-ROOM-DEVICE form, so keep the room it came with
+			device.BasicRoomInfo = GenerateBasicRoomInfo(e.TargetDevice.RoomID)
+			if len(e.TargetDevice.RoomID) == 0 {
+				device.BasicRoomInfo = BasicRoomInfo{}
+			}
+		}
+
+		if device.DeviceID != e.TargetDevice.DeviceID {
+			fixable("target device %q isn't uppercase", e.TargetDevice.DeviceID)
+		}
+
+		if len(e.TargetDevice.RoomID) > 0 && !strings.EqualFold(e.TargetDevice.RoomID, device.RoomID) {
+			fixable("target device's room %q doesn't match its ID %q", e.TargetDevice.RoomID, device.DeviceID)
+		}
+	}
+
+	room := e.AffectedRoom
+	switch {
+	case len(room.RoomID) > 0:
+		room = GenerateBasicRoomInfo(room.RoomID)
+		if room.RoomID != e.AffectedRoom.RoomID {
+			fixable("affected room %q isn't uppercase", e.AffectedRoom.RoomID)
+		}
+
+		if len(e.AffectedRoom.BuildingID) > 0 && !strings.EqualFold(e.AffectedRoom.BuildingID, room.BuildingID) {
+			fixable("affected room's building %q doesn't match its ID %q", e.AffectedRoom.BuildingID, room.RoomID)
+		}
+	case len(device.RoomID) > 0:
+		fixable("affected room is missing")
+		room = device.BasicRoomInfo
+	case len(room.BuildingID) > 0:
+		room.BuildingID = strings.ToUpper(room.BuildingID)
+		if room.BuildingID != e.AffectedRoom.BuildingID {
+			fixable("affected building %q isn't uppercase", e.AffectedRoom.BuildingID)
+		}
+	}
+
+	if len(device.RoomID) > 0 && len(room.RoomID) > 0 && device.RoomID != room.RoomID {
+		problem("target device %q isn't in the affected room %q", device.DeviceID, room.RoomID)
+	}
+
+	e.TargetDevice = device
+	e.AffectedRoom = room
+
+	// tags
+	tags := make([]string, 0, len(e.EventTags))
+	seen := make(map[string]bool)
+
+	for _, tag := range e.EventTags {
+		trimmed := strings.TrimSpace(tag)
+
+		switch {
+		case len(trimmed) == 0:
+			fixable("tags include an empty tag")
+			continue
+		case seen[trimmed]:
+			fixable("tag %q is duplicated", trimmed)
+			continue
+		case trimmed != tag:
+			fixable("tag %q has extra whitespace", tag)
+		}
+
+		if v.Strict && !v.knownTag(trimmed) {
+			problem("tag %q is unknown", trimmed)
+		}
+
+		seen[trimmed] = true
+		tags = append(tags, trimmed)
+	}
+
+	e.EventTags = tags
+
+	// data
+	if e.Data != nil && v.MaxDataSize > 0 {
+		data, err := json.Marshal(e.Data)
+		switch {
+		case err != nil:
+			problem("data can't be marshaled to json: %s", err)
+		case len(data) > v.MaxDataSize:
+			problem("data is %v bytes, which is more than the max of %v", len(data), v.MaxDataSize)
+		}
+	}
+
+	return e, problems
+}
+
+func (v Validator) knownTag(tag string) bool {
+	for _, known := range KnownTags {
+		if tag == known {
+			return true
+		}
+	}
+
+	for _, allowed := range v.AllowedTags {
+		if tag == allowed {
+			return true
+		}
+	}
+
+	return false
+}
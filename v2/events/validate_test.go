@@ -0,0 +1,46 @@
+package events
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	e := Event{
+		Key:              "power",
+		GeneratingSystem: "ITB-1101-CP1",
+		TargetDevice:     BasicDeviceInfo{DeviceID: "itb-1101-d1"},
+		EventTags:        []string{CoreState, " " + CoreState, "my-tag"},
+	}
+
+	if err := Validate(e); err == nil {
+		t.Fatalf("expected the event to be invalid before it's normalized")
+	}
+
+	e, err := Normalize(e)
+	if err != nil {
+		t.Fatalf("failed to normalize event: %s", err)
+	}
+
+	if e.TargetDevice.DeviceID != "ITB-1101-D1" || e.AffectedRoom.RoomID != "ITB-1101" || e.AffectedRoom.BuildingID != "ITB" {
+		t.Fatalf("expected the device and room to be filled in, got %+v, %+v", e.TargetDevice, e.AffectedRoom)
+	}
+
+	if e.Timestamp.IsZero() || len(e.EventTags) != 2 {
+		t.Fatalf("expected the timestamp to be filled in and tags deduplicated, got %v, %v", e.Timestamp, e.EventTags)
+	}
+
+	if err := Validate(e); err != nil {
+		t.Fatalf("expected the normalized event to be valid: %s", err)
+	}
+
+	strict := Validator{Strict: true, MaxDataSize: 10}
+	e.AffectedRoom = GenerateBasicRoomInfo("ITB-1108")
+	e.Data = strings.Repeat("a", 20)
+
+	_, err = strict.Normalize(e)
+	verr, ok := err.(*ValidationError)
+	if !ok || len(verr.Problems) != 3 {
+		t.Fatalf("expected the room, tag, and data to be invalid, got %v", err)
+	}
+}